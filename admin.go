@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// userSummary возвращает представление пользователя для административного API без хеша пароля.
+func userSummary(user User) UserSummary {
+	role := user.Role
+	if role == "" {
+		role = RoleUser
+	}
+	return UserSummary{
+		ID:                 user.ID,
+		Name:               user.Name,
+		Phone:              user.Phone,
+		Role:               role,
+		PhoneVerified:      user.PhoneVerified,
+		TOTPEnabled:        user.TOTPEnabled,
+		Disabled:           user.Disabled,
+		MustChangePassword: user.MustChangePassword,
+	}
+}
+
+// findUserByParam ищет пользователя по идентификатору из параметра пути и отвечает клиенту при ошибке.
+func (h *Handler) findUserByParam(c *gin.Context) (User, bool) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return User{}, false
+	}
+
+	user, err := h.users.FindByID(context.Background(), objID)
+	if errors.Is(err, errUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return User{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return User{}, false
+	}
+	return user, true
+}
+
+// AdminListUsers godoc
+//
+//	@Summary		List users
+//	@Description	List users page by page, optionally searching by phone or name
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			q		query		string					false	"Search by phone or name"
+//	@Param			page	query		int						false	"Page number, starting from 1"
+//	@Param			limit	query		int						false	"Page size, at most 100"
+//	@Success		200		{object}	map[string]interface{}	"Page of users"
+//	@Failure		403		{object}	map[string]string		"Insufficient permissions"
+//	@Failure		500		{object}	map[string]string		"Failed to fetch users"
+//	@Router			/admin/users [get]
+func (h *Handler) AdminListUsers(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		limit = defaultPageSize
+	}
+
+	users, total, err := h.users.List(context.Background(), c.Query("q"), int64((page-1)*limit), int64(limit))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	summaries := make([]UserSummary, 0, len(users))
+	for _, user := range users {
+		summaries = append(summaries, userSummary(user))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": summaries,
+		"page":  page,
+		"limit": limit,
+		"total": total,
+	})
+}
+
+// AdminSetUserDisabled godoc
+//
+//	@Summary		Disable or enable a user
+//	@Description	Disable or enable a user account. Disabling also terminates all of the user's sessions.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id		path		string				true	"User ID"
+//	@Param			request	body		SetDisabledRequest	true	"Disabled flag"
+//	@Success		200		{object}	UserSummary			"Updated user"
+//	@Failure		400		{object}	map[string]string	"Invalid user ID or input"
+//	@Failure		404		{object}	map[string]string	"User not found"
+//	@Failure		500		{object}	map[string]string	"Failed to update user"
+//	@Router			/admin/users/{id}/disabled [put]
+func (h *Handler) AdminSetUserDisabled(c *gin.Context) {
+	var req SetDisabledRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.findUserByParam(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.users.Update(context.Background(), user.ID, UserUpdate{Disabled: req.Disabled})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+	if *req.Disabled {
+		if err := h.revokeAllSessions(context.Background(), user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate sessions"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, userSummary(user))
+}
+
+// AdminResetPassword godoc
+//
+//	@Summary		Force a password reset
+//	@Description	Replace the user's password with a generated temporary one and terminate all of the user's sessions. Until the user changes the temporary password, only /protected/password and /logout are available.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string				true	"User ID"
+//	@Success		200	{object}	map[string]string	"Temporary password"
+//	@Failure		400	{object}	map[string]string	"Invalid user ID"
+//	@Failure		404	{object}	map[string]string	"User not found"
+//	@Failure		500	{object}	map[string]string	"Failed to reset password"
+//	@Router			/admin/users/{id}/password-reset [post]
+func (h *Handler) AdminResetPassword(c *gin.Context) {
+	user, ok := h.findUserByParam(c)
+	if !ok {
+		return
+	}
+
+	temporaryPassword, err := generateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	hashedPassword, err := HashPassword(temporaryPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+
+	mustChange := true
+	_, err = h.users.Update(context.Background(), user.ID, UserUpdate{Password: &hashedPassword, MustChangePassword: &mustChange})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	if err := h.revokeAllSessions(context.Background(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"temporary_password": temporaryPassword})
+}
+
+// AdminSetUserRole godoc
+//
+//	@Summary		Assign a role
+//	@Description	Assign a role to a user. Outstanding access tokens are invalidated so the new role applies on the next refresh.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id		path		string				true	"User ID"
+//	@Param			request	body		SetRoleRequest		true	"Role"
+//	@Success		200		{object}	UserSummary			"Updated user"
+//	@Failure		400		{object}	map[string]string	"Invalid user ID or role"
+//	@Failure		404		{object}	map[string]string	"User not found"
+//	@Failure		500		{object}	map[string]string	"Failed to update user"
+//	@Router			/admin/users/{id}/role [put]
+func (h *Handler) AdminSetUserRole(c *gin.Context) {
+	var req SetRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+		return
+	}
+
+	user, ok := h.findUserByParam(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.users.Update(context.Background(), user.ID, UserUpdate{Role: &req.Role, IncTokenVersion: true})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, userSummary(user))
+}
+
+// AdminDeleteUser godoc
+//
+//	@Summary		Delete a user
+//	@Description	Permanently delete a user together with their posts, sessions and refresh tokens
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string				true	"User ID"
+//	@Success		200	{object}	map[string]string	"User deleted successfully"
+//	@Failure		400	{object}	map[string]string	"Invalid user ID"
+//	@Failure		404	{object}	map[string]string	"User not found"
+//	@Failure		500	{object}	map[string]string	"Failed to delete user"
+//	@Router			/admin/users/{id} [delete]
+func (h *Handler) AdminDeleteUser(c *gin.Context) {
+	user, ok := h.findUserByParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.posts.DeleteByAuthor(context.Background(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user posts"})
+		return
+	}
+	if err := h.sessions.DeleteByPhone(context.Background(), user.Phone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user sessions"})
+		return
+	}
+	if err := h.refreshTokens.DeleteByPhone(context.Background(), user.Phone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user sessions"})
+		return
+	}
+	if err := h.webAuthnCredentials.DeleteByUser(context.Background(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user passkeys"})
+		return
+	}
+	if err := h.apiKeys.DeleteByUser(context.Background(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user API keys"})
+		return
+	}
+	if err := h.users.Delete(context.Background(), user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
+
+// runUserCommand выполняет команду user promote <phone> [role]: назначает роль (по умолчанию admin)
+// напрямую в хранилище. Так выдается роль первому администратору, пока назначить ее через API некому.
+func runUserCommand(args []string) {
+	if len(args) < 2 || len(args) > 3 || args[0] != "promote" {
+		fmt.Fprintln(os.Stderr, "usage: user promote <phone> [role]")
+		os.Exit(2)
+	}
+	role := RoleAdmin
+	if len(args) == 3 {
+		role = args[2]
+	}
+	if !validRole(role) {
+		log.Fatalf("unknown role %q", role)
+	}
+	phone, err := normalizePhone(args[1])
+	if err != nil {
+		log.Fatalf("%s: %v", args[1], err)
+	}
+
+	ctx := context.Background()
+	users := initStores().users
+	user, err := users.FindByPhone(ctx, phone)
+	if errors.Is(err, errUserNotFound) {
+		log.Fatalf("no user with phone %s, register the account first", phone)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Outstanding access tokens carry the old role, same as AdminSetUserRole
+	if _, err := users.Update(ctx, user.ID, UserUpdate{Role: &role, IncTokenVersion: true}); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("User %s (%s) now has role %s\n", user.ID.Hex(), phone, role)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ScopeProfileRead = "profile:read"
+	ScopePostsRead   = "posts:read"
+	ScopePostsWrite  = "posts:write"
+)
+
+const (
+	apiKeyPrefix      = "ak_"
+	apiKeyLastUsedLag = time.Minute
+)
+
+// apiKeyScopes перечисляет scope, которые можно выдать API-ключу.
+var apiKeyScopes = []string{ScopeProfileRead, ScopePostsRead, ScopePostsWrite}
+
+var errAPIKeyInvalid = errors.New("invalid API key")
+
+// generateAPIKey генерирует API-ключ вида ak_<prefix>_<secret> и возвращает его вместе с видимым префиксом.
+func generateAPIKey() (string, string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyPrefix + hex.EncodeToString(b)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// authenticateAPIKey находит API-ключ по хешу и возвращает его вместе с владельцем.
+func (h *Handler) authenticateAPIKey(ctx context.Context, key string) (APIKey, User, error) {
+	apiKey, err := h.apiKeys.FindByHash(ctx, hashToken(key))
+	if errors.Is(err, errAPIKeyNotFound) {
+		return APIKey{}, User{}, errAPIKeyInvalid
+	}
+	if err != nil {
+		return APIKey{}, User{}, err
+	}
+
+	user, err := h.users.FindByID(ctx, apiKey.UserID)
+	if errors.Is(err, errUserNotFound) {
+		return APIKey{}, User{}, errAPIKeyInvalid
+	}
+	if err != nil {
+		return APIKey{}, User{}, err
+	}
+	if user.Disabled {
+		return APIKey{}, User{}, errAccountDisabled
+	}
+
+	// Avoid a write on every request from busy scripts
+	if time.Since(apiKey.LastUsedAt) > apiKeyLastUsedLag {
+		if err := h.apiKeys.Touch(ctx, apiKey.ID, time.Now()); err != nil {
+			return APIKey{}, User{}, err
+		}
+	}
+	return apiKey, user, nil
+}
+
+// ListAPIKeys godoc
+//
+//	@Summary		List API keys
+//	@Description	List personal API keys of the authenticated user. Only the key prefix is shown.
+//	@Tags			APIKey
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{array}		APIKey				"List of API keys"
+//	@Failure		500	{object}	map[string]string	"Failed to fetch API keys"
+//	@Router			/protected/api-keys [get]
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeys.ListByUser(context.Background(), currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey godoc
+//
+//	@Summary		Create an API key
+//	@Description	Create a personal API key for scripts. The key is returned only once; send it as "Authorization: ApiKey <key>".
+//	@Tags			APIKey
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			request	body		CreateAPIKeyRequest		true	"Key name and scopes"
+//	@Success		201		{object}	map[string]interface{}	"Created API key"
+//	@Failure		400		{object}	map[string]string		"Invalid input or unknown scope"
+//	@Failure		500		{object}	map[string]string		"Failed to create API key"
+//	@Router			/protected/api-keys [post]
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !containsString(apiKeyScopes, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope " + scope})
+			return
+		}
+	}
+
+	userID := currentUserID(c)
+
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	apiKey := APIKey{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    prefix,
+		KeyHash:   hashToken(key),
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now(),
+	}
+	if err := h.apiKeys.Create(context.Background(), apiKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"key": key, "api_key": apiKey})
+}
+
+// RenameAPIKey godoc
+//
+//	@Summary		Rename an API key
+//	@Description	Change the name of one of the authenticated user's API keys
+//	@Tags			APIKey
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id		path		string				true	"API key ID"
+//	@Param			request	body		RenameAPIKeyRequest	true	"New name"
+//	@Success		200		{object}	map[string]string	"API key renamed"
+//	@Failure		400		{object}	map[string]string	"Invalid input or API key ID"
+//	@Failure		404		{object}	map[string]string	"API key not found"
+//	@Failure		500		{object}	map[string]string	"Failed to rename API key"
+//	@Router			/protected/api-keys/{id} [patch]
+func (h *Handler) RenameAPIKey(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	var req RenameAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := currentUserID(c)
+
+	// Ensure the key belongs to the authenticated user
+	err = h.apiKeys.Rename(context.Background(), objID, userID, strings.TrimSpace(req.Name))
+	if errors.Is(err, errAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key renamed successfully"})
+}
+
+// RevokeAPIKey godoc
+//
+//	@Summary		Revoke an API key
+//	@Description	Revoke one of the authenticated user's API keys. Requests with the key are rejected immediately.
+//	@Tags			APIKey
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string				true	"API key ID"
+//	@Success		200	{object}	map[string]string	"API key revoked"
+//	@Failure		400	{object}	map[string]string	"Invalid API key ID"
+//	@Failure		404	{object}	map[string]string	"API key not found"
+//	@Failure		500	{object}	map[string]string	"Failed to revoke API key"
+//	@Router			/protected/api-keys/{id} [delete]
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	userID := currentUserID(c)
+
+	// Ensure the key belongs to the authenticated user
+	err = h.apiKeys.Delete(context.Background(), objID, userID)
+	if errors.Is(err, errAPIKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
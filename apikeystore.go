@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errAPIKeyNotFound = errors.New("api key not found")
+
+// APIKeyStore хранит API-ключи пользователей. Сам ключ не хранится, только его хеш.
+// Методы с userID затрагивают ключ, только если он принадлежит этому пользователю.
+type APIKeyStore interface {
+	// Create сохраняет ключ с заранее заданным идентификатором.
+	Create(ctx context.Context, key APIKey) error
+	// FindByHash возвращает ключ по хешу или errAPIKeyNotFound.
+	FindByHash(ctx context.Context, hash string) (APIKey, error)
+	// ListByUser возвращает ключи пользователя в порядке создания.
+	ListByUser(ctx context.Context, userID primitive.ObjectID) ([]APIKey, error)
+	Rename(ctx context.Context, id, userID primitive.ObjectID, name string) error
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error
+	Delete(ctx context.Context, id, userID primitive.ObjectID) error
+	DeleteByUser(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// mongoAPIKeyStore хранит API-ключи в коллекции MongoDB.
+type mongoAPIKeyStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoAPIKeyStore) Create(ctx context.Context, key APIKey) error {
+	_, err := s.collection.InsertOne(ctx, key)
+	return err
+}
+
+func (s *mongoAPIKeyStore) FindByHash(ctx context.Context, hash string) (APIKey, error) {
+	var key APIKey
+	err := s.collection.FindOne(ctx, bson.M{"key_hash": hash}).Decode(&key)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return APIKey{}, errAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (s *mongoAPIKeyStore) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]APIKey, error) {
+	cursor, err := s.collection.Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	keys := []APIKey{}
+	if err = cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *mongoAPIKeyStore) Rename(ctx context.Context, id, userID primitive.ObjectID, name string) error {
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "user_id": userID},
+		bson.M{"$set": bson.M{"name": name}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errAPIKeyNotFound
+	}
+	return nil
+}
+
+func (s *mongoAPIKeyStore) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+func (s *mongoAPIKeyStore) Delete(ctx context.Context, id, userID primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errAPIKeyNotFound
+	}
+	return nil
+}
+
+func (s *mongoAPIKeyStore) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// memoryAPIKeyStore хранит API-ключи в памяти процесса.
+type memoryAPIKeyStore struct {
+	mu   sync.Mutex
+	keys map[primitive.ObjectID]APIKey
+}
+
+func newMemoryAPIKeyStore() *memoryAPIKeyStore {
+	return &memoryAPIKeyStore{keys: make(map[primitive.ObjectID]APIKey)}
+}
+
+func (s *memoryAPIKeyStore) Create(_ context.Context, key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *memoryAPIKeyStore) FindByHash(_ context.Context, hash string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.keys {
+		if key.KeyHash == hash {
+			return key, nil
+		}
+	}
+	return APIKey{}, errAPIKeyNotFound
+}
+
+func (s *memoryAPIKeyStore) ListByUser(_ context.Context, userID primitive.ObjectID) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []APIKey{}
+	for _, key := range s.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID.Hex() < keys[j].ID.Hex() })
+	return keys, nil
+}
+
+func (s *memoryAPIKeyStore) Rename(_ context.Context, id, userID primitive.ObjectID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok || key.UserID != userID {
+		return errAPIKeyNotFound
+	}
+	key.Name = name
+	s.keys[id] = key
+	return nil
+}
+
+func (s *memoryAPIKeyStore) Touch(_ context.Context, id primitive.ObjectID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[id]; ok {
+		key.LastUsedAt = at
+		s.keys[id] = key
+	}
+	return nil
+}
+
+func (s *memoryAPIKeyStore) Delete(_ context.Context, id, userID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok || key.UserID != userID {
+		return errAPIKeyNotFound
+	}
+	delete(s.keys, id)
+	return nil
+}
+
+func (s *memoryAPIKeyStore) DeleteByUser(_ context.Context, userID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, key := range s.keys {
+		if key.UserID == userID {
+			delete(s.keys, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const boltOpenTimeout = time.Second
+
+var (
+	boltUsersBucket      = []byte("users")
+	boltUsersPhoneBucket = []byte("users_by_phone")
+	boltPostsBucket      = []byte("posts")
+)
+
+// boltBuckets - бакеты, которые initBolt создает при открытии базы.
+var boltBuckets = [][]byte{
+	boltUsersBucket, boltUsersPhoneBucket, boltPostsBucket,
+	boltSessionsBucket, boltRefreshTokensBucket, boltRevokedTokensBucket, boltOTPCodesBucket,
+	boltLoginAttemptsBucket, boltAPIKeysBucket, boltAPIKeysHashBucket, boltWebAuthnCredentialsBucket,
+	boltWebAuthnCredentialIDBucket, boltWebAuthnCeremoniesBucket, boltOAuthClientsBucket, boltOAuthCodesBucket,
+}
+
+// initBolt открывает встроенную базу bbolt по пути BOLT_PATH и создает недостающие бакеты.
+// Файл блокируется, поэтому с одной базой может работать только один процесс.
+func initBolt() *bolt.DB {
+	path := config.BoltPath
+	db, err := openBolt(path)
+	if err != nil {
+		log.Fatalf("failed to open %s: %v", path, err)
+	}
+
+	log.Printf("Opened embedded database %s", path)
+	return db
+}
+
+// openBolt открывает базу bbolt и создает недостающие бакеты.
+func openBolt(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// boltUserStore хранит пользователей в бакете users с ключом по hex-идентификатору.
+// Бакет users_by_phone отображает телефон в идентификатор и обеспечивает уникальность телефона.
+type boltUserStore struct {
+	db *bolt.DB
+}
+
+// getBoltUser читает пользователя внутри транзакции.
+func getBoltUser(tx *bolt.Tx, id primitive.ObjectID) (User, error) {
+	data := tx.Bucket(boltUsersBucket).Get([]byte(id.Hex()))
+	if data == nil {
+		return User{}, errUserNotFound
+	}
+	var user User
+	err := bson.Unmarshal(data, &user)
+	return user, err
+}
+
+// putBoltUser сохраняет пользователя и обновляет индекс телефонов.
+// previousPhone - телефон до изменения, пустой для нового пользователя.
+func putBoltUser(tx *bolt.Tx, user User, previousPhone string) error {
+	phones := tx.Bucket(boltUsersPhoneBucket)
+	if user.Phone != previousPhone {
+		if owner := phones.Get([]byte(user.Phone)); owner != nil && string(owner) != user.ID.Hex() {
+			return errPhoneTaken
+		}
+		if previousPhone != "" {
+			if err := phones.Delete([]byte(previousPhone)); err != nil {
+				return err
+			}
+		}
+		if err := phones.Put([]byte(user.Phone), []byte(user.ID.Hex())); err != nil {
+			return err
+		}
+	}
+
+	data, err := bson.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltUsersBucket).Put([]byte(user.ID.Hex()), data)
+}
+
+func (s *boltUserStore) Create(_ context.Context, user User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltUser(tx, user, "")
+	})
+}
+
+func (s *boltUserStore) FindByID(_ context.Context, id primitive.ObjectID) (User, error) {
+	var user User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user, err = getBoltUser(tx, id)
+		return err
+	})
+	return user, err
+}
+
+func (s *boltUserStore) FindByPhone(_ context.Context, phone string) (User, error) {
+	var user User
+	err := s.db.View(func(tx *bolt.Tx) error {
+		hex := tx.Bucket(boltUsersPhoneBucket).Get([]byte(phone))
+		if hex == nil {
+			return errUserNotFound
+		}
+		id, err := primitive.ObjectIDFromHex(string(hex))
+		if err != nil {
+			return err
+		}
+		user, err = getBoltUser(tx, id)
+		return err
+	})
+	return user, err
+}
+
+func (s *boltUserStore) List(_ context.Context, search string, skip, limit int64) ([]User, int64, error) {
+	search = strings.ToLower(search)
+	users := []User{}
+	var total int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Keys are hex ObjectIDs, so the cursor walks users in creation order
+		return tx.Bucket(boltUsersBucket).ForEach(func(_, data []byte) error {
+			var user User
+			if err := bson.Unmarshal(data, &user); err != nil {
+				return err
+			}
+			if search != "" && !strings.Contains(strings.ToLower(user.Phone), search) && !strings.Contains(strings.ToLower(user.Name), search) {
+				return nil
+			}
+			if total >= skip && (limit <= 0 || int64(len(users)) < limit) {
+				users = append(users, user)
+			}
+			total++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (s *boltUserStore) Update(_ context.Context, id primitive.ObjectID, u UserUpdate) (User, error) {
+	var user User
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		user, err = getBoltUser(tx, id)
+		if err != nil {
+			return err
+		}
+		previousPhone := user.Phone
+		u.apply(&user)
+		return putBoltUser(tx, user, previousPhone)
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// updateIf применяет изменение, если пользователь подходит под условие.
+func (s *boltUserStore) updateIf(id primitive.ObjectID, match func(User) bool, change func(*User)) (bool, error) {
+	applied := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		user, err := getBoltUser(tx, id)
+		if errors.Is(err, errUserNotFound) || (err == nil && !match(user)) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		change(&user)
+		applied = true
+		return putBoltUser(tx, user, user.Phone)
+	})
+	return applied, err
+}
+
+func (s *boltUserStore) ReplacePassword(_ context.Context, id primitive.ObjectID, current, hash string) (bool, error) {
+	return s.updateIf(id,
+		func(user User) bool { return user.Password == current },
+		func(user *User) { user.Password = hash },
+	)
+}
+
+func (s *boltUserStore) UseTOTPStep(_ context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	return s.updateIf(id,
+		func(user User) bool { return user.TOTPLastStep < step },
+		func(user *User) { user.TOTPLastStep = step },
+	)
+}
+
+func (s *boltUserStore) UseRecoveryCode(_ context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	return s.updateIf(id,
+		func(user User) bool { return containsString(user.RecoveryCodes, hash) },
+		func(user *User) {
+			codes := make([]string, 0, len(user.RecoveryCodes))
+			for _, code := range user.RecoveryCodes {
+				if code != hash {
+					codes = append(codes, code)
+				}
+			}
+			user.RecoveryCodes = codes
+		},
+	)
+}
+
+func (s *boltUserStore) Delete(_ context.Context, id primitive.ObjectID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		user, err := getBoltUser(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltUsersPhoneBucket).Delete([]byte(user.Phone)); err != nil {
+			return err
+		}
+		return tx.Bucket(boltUsersBucket).Delete([]byte(id.Hex()))
+	})
+}
+
+// boltPostStore хранит посты в бакете posts с ключом по hex-идентификатору.
+type boltPostStore struct {
+	db *bolt.DB
+}
+
+// getBoltPost читает пост внутри транзакции, если он существует и принадлежит автору.
+func getBoltPost(tx *bolt.Tx, id, authorID primitive.ObjectID) (Post, error) {
+	data := tx.Bucket(boltPostsBucket).Get([]byte(id.Hex()))
+	if data == nil {
+		return Post{}, errPostNotFound
+	}
+	var post Post
+	if err := bson.Unmarshal(data, &post); err != nil {
+		return Post{}, err
+	}
+	if !authorID.IsZero() && post.AuthorID != authorID {
+		return Post{}, errPostNotFound
+	}
+	return post, nil
+}
+
+func putBoltPost(tx *bolt.Tx, post Post) error {
+	data, err := bson.Marshal(post)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltPostsBucket).Put([]byte(post.ID.Hex()), data)
+}
+
+func (s *boltPostStore) Create(_ context.Context, post Post) error {
+	if post.ID.IsZero() {
+		post.ID = primitive.NewObjectID()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltPost(tx, post)
+	})
+}
+
+func (s *boltPostStore) List(_ context.Context) ([]Post, error) {
+	posts := []Post{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Keys are hex ObjectIDs, so the cursor walks posts in creation order
+		return tx.Bucket(boltPostsBucket).ForEach(func(_, data []byte) error {
+			var post Post
+			if err := bson.Unmarshal(data, &post); err != nil {
+				return err
+			}
+			posts = append(posts, post)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (s *boltPostStore) Update(_ context.Context, id, authorID primitive.ObjectID, post Post) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := getBoltPost(tx, id, authorID)
+		if err != nil {
+			return err
+		}
+		stored.Title = post.Title
+		stored.Text = post.Text
+		stored.Image = post.Image
+		stored.Date = post.Date
+		return putBoltPost(tx, stored)
+	})
+}
+
+func (s *boltPostStore) Delete(_ context.Context, id, authorID primitive.ObjectID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := getBoltPost(tx, id, authorID); err != nil {
+			return err
+		}
+		return tx.Bucket(boltPostsBucket).Delete([]byte(id.Hex()))
+	})
+}
+
+func (s *boltPostStore) DeleteByAuthor(_ context.Context, authorID primitive.ObjectID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltPostsBucket)
+		var ids [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			var post Post
+			if err := bson.Unmarshal(data, &post); err != nil {
+				return err
+			}
+			if post.AuthorID == authorID {
+				ids = append(ids, bytes.Clone(key))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Deleting while iterating with ForEach is not allowed
+		for _, id := range ids {
+			if err := bucket.Delete(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
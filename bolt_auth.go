@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	boltSessionsBucket             = []byte("sessions")
+	boltRefreshTokensBucket        = []byte("refresh_tokens")
+	boltRevokedTokensBucket        = []byte("revoked_tokens")
+	boltOTPCodesBucket             = []byte("otp_codes")
+	boltLoginAttemptsBucket        = []byte("login_attempts")
+	boltAPIKeysBucket              = []byte("api_keys")
+	boltAPIKeysHashBucket          = []byte("api_keys_by_hash")
+	boltWebAuthnCredentialsBucket  = []byte("webauthn_credentials")
+	boltWebAuthnCredentialIDBucket = []byte("webauthn_credentials_by_credential_id")
+	boltWebAuthnCeremoniesBucket   = []byte("webauthn_ceremonies")
+	boltOAuthClientsBucket         = []byte("oauth_clients")
+	boltOAuthCodesBucket           = []byte("oauth_codes")
+)
+
+// boltPurgeInterval - как часто удаляются просроченные записи.
+const boltPurgeInterval = 10 * time.Minute
+
+// boltExpiry - бакеты с истекающими записями: поле времени документа и сколько запись живет после него.
+// Заменяют TTL-индексы MongoDB.
+var boltExpiry = []struct {
+	bucket []byte
+	field  string
+	after  time.Duration
+}{
+	{boltRefreshTokensBucket, "expires_at", 0},
+	{boltRevokedTokensBucket, "expires_at", 0},
+	{boltOTPCodesBucket, "expires_at", 0},
+	{boltOAuthCodesBucket, "expires_at", 0},
+	{boltLoginAttemptsBucket, "expires_at", 0},
+	{boltWebAuthnCeremoniesBucket, "expires_at", 0},
+	// A session idle for longer than a refresh token lives can no longer be resumed
+	{boltSessionsBucket, "last_seen_at", refreshTokenTTL},
+}
+
+// purgeExpiredBolt удаляет записи, срок которых истек к моменту now.
+func purgeExpiredBolt(db *bolt.DB, now time.Time) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, expiry := range boltExpiry {
+			bucket := tx.Bucket(expiry.bucket)
+			var expired [][]byte
+			err := bucket.ForEach(func(key, data []byte) error {
+				at, ok := bson.Raw(data).Lookup(expiry.field).TimeOK()
+				if ok && at.Add(expiry.after).Before(now) {
+					expired = append(expired, bytes.Clone(key))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, key := range expired {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// startBoltPurge периодически удаляет просроченные записи в фоне.
+func startBoltPurge(db *bolt.DB) {
+	go func() {
+		for range time.Tick(boltPurgeInterval) {
+			if err := purgeExpiredBolt(db, time.Now()); err != nil {
+				log.Println("Error purging expired bolt records:", err)
+			}
+		}
+	}()
+}
+
+// newBoltStores создает хранилища поверх бакетов базы bbolt. Базу открывает один процесс,
+// поэтому лимиты запросов хранятся в памяти.
+func newBoltStores(db *bolt.DB) Stores {
+	return Stores{
+		users:               &boltUserStore{db: db},
+		posts:               &boltPostStore{db: db},
+		sessions:            &boltSessionStore{db: db},
+		refreshTokens:       &boltRefreshTokenStore{db: db},
+		revokedTokens:       &boltRevokedTokenStore{db: db},
+		otpCodes:            &boltOTPStore{db: db},
+		loginAttempts:       &boltLoginAttemptStore{db: db},
+		rateLimits:          newMemoryRateLimitStore(),
+		apiKeys:             &boltAPIKeyStore{db: db},
+		webAuthnCredentials: &boltWebAuthnCredentialStore{db: db},
+		webAuthnCeremonies:  &boltWebAuthnCeremonyStore{db: db},
+		oauthClients:        &boltOAuthClientStore{db: db},
+		oauthCodes:          &boltAuthorizationCodeStore{db: db},
+	}
+}
+
+// getBoltDoc читает BSON-документ по ключу в v и сообщает, был ли он найден.
+func getBoltDoc(tx *bolt.Tx, bucket, key []byte, v interface{}) (bool, error) {
+	data := tx.Bucket(bucket).Get(key)
+	if data == nil {
+		return false, nil
+	}
+	return true, bson.Unmarshal(data, v)
+}
+
+// putBoltDoc сохраняет v как BSON-документ по ключу.
+func putBoltDoc(tx *bolt.Tx, bucket, key []byte, v interface{}) error {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put(key, data)
+}
+
+// boltSessionStore хранит сессии в бакете sessions с ключом по hex-идентификатору.
+// Сессий у пользователя немного, поэтому выборки по телефону обходят весь бакет.
+type boltSessionStore struct {
+	db *bolt.DB
+}
+
+// updateBoltSessions применяет change к сессиям, подходящим под match, а при nil change удаляет их.
+func updateBoltSessions(tx *bolt.Tx, match func(Session) bool, change func(*Session)) error {
+	var matched []Session
+	err := tx.Bucket(boltSessionsBucket).ForEach(func(_, data []byte) error {
+		var session Session
+		if err := bson.Unmarshal(data, &session); err != nil {
+			return err
+		}
+		if match(session) {
+			matched = append(matched, session)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Changing the bucket while iterating with ForEach is not allowed
+	for _, session := range matched {
+		key := []byte(session.ID.Hex())
+		if change == nil {
+			err = tx.Bucket(boltSessionsBucket).Delete(key)
+		} else {
+			change(&session)
+			err = putBoltDoc(tx, boltSessionsBucket, key, session)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltSessionStore) Create(_ context.Context, session Session) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltDoc(tx, boltSessionsBucket, []byte(session.ID.Hex()), session)
+	})
+}
+
+// change применяет изменение к незавершенной сессии id, если она подходит под match.
+func (s *boltSessionStore) change(id primitive.ObjectID, match func(Session) bool, change func(*Session)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var session Session
+		found, err := getBoltDoc(tx, boltSessionsBucket, []byte(id.Hex()), &session)
+		if err != nil {
+			return err
+		}
+		if !found || session.Revoked || !match(session) {
+			return errSessionNotFound
+		}
+		change(&session)
+		return putBoltDoc(tx, boltSessionsBucket, []byte(id.Hex()), session)
+	})
+}
+
+func (s *boltSessionStore) Touch(_ context.Context, id primitive.ObjectID, phone, ip string, at time.Time) error {
+	return s.change(id,
+		func(session Session) bool { return session.Phone == phone },
+		func(session *Session) {
+			session.LastSeenAt = at
+			session.IP = ip
+		},
+	)
+}
+
+func (s *boltSessionStore) Find(_ context.Context, id primitive.ObjectID, phone string) (Session, error) {
+	var session Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found, err := getBoltDoc(tx, boltSessionsBucket, []byte(id.Hex()), &session)
+		if err != nil {
+			return err
+		}
+		if !found || session.Phone != phone || session.Revoked {
+			return errSessionNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (s *boltSessionStore) ListActive(_ context.Context, phone string) ([]Session, error) {
+	sessions := []Session{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// Keys are hex ObjectIDs, so the cursor walks sessions in creation order
+		return tx.Bucket(boltSessionsBucket).ForEach(func(_, data []byte) error {
+			var session Session
+			if err := bson.Unmarshal(data, &session); err != nil {
+				return err
+			}
+			if session.Phone == phone && !session.Revoked {
+				sessions = append(sessions, session)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *boltSessionStore) Revoke(_ context.Context, id primitive.ObjectID, phone string) error {
+	return s.change(id,
+		func(session Session) bool { return phone == "" || session.Phone == phone },
+		func(session *Session) { session.Revoked = true },
+	)
+}
+
+func (s *boltSessionStore) RevokeAll(_ context.Context, phone string, except primitive.ObjectID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateBoltSessions(tx,
+			func(session Session) bool { return session.Phone == phone && session.ID != except && !session.Revoked },
+			func(session *Session) { session.Revoked = true },
+		)
+	})
+}
+
+func (s *boltSessionStore) ChangePhone(_ context.Context, oldPhone, newPhone string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateBoltSessions(tx,
+			func(session Session) bool { return session.Phone == oldPhone },
+			func(session *Session) { session.Phone = newPhone },
+		)
+	})
+}
+
+func (s *boltSessionStore) DeleteByPhone(_ context.Context, phone string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateBoltSessions(tx, func(session Session) bool { return session.Phone == phone }, nil)
+	})
+}
+
+// boltRefreshTokenStore хранит refresh-токены в бакете refresh_tokens с ключом по хешу.
+type boltRefreshTokenStore struct {
+	db *bolt.DB
+}
+
+// updateBoltRefreshTokens применяет change к токенам, подходящим под match, а при nil change удаляет их.
+func updateBoltRefreshTokens(tx *bolt.Tx, match func(RefreshToken) bool, change func(*RefreshToken)) error {
+	var matched []RefreshToken
+	err := tx.Bucket(boltRefreshTokensBucket).ForEach(func(_, data []byte) error {
+		var token RefreshToken
+		if err := bson.Unmarshal(data, &token); err != nil {
+			return err
+		}
+		if match(token) {
+			matched = append(matched, token)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, token := range matched {
+		key := []byte(token.Hash)
+		if change == nil {
+			err = tx.Bucket(boltRefreshTokensBucket).Delete(key)
+		} else {
+			change(&token)
+			err = putBoltDoc(tx, boltRefreshTokensBucket, key, token)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltRefreshTokenStore) Create(_ context.Context, token RefreshToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltDoc(tx, boltRefreshTokensBucket, []byte(token.Hash), token)
+	})
+}
+
+func (s *boltRefreshTokenStore) Use(_ context.Context, hash string) (RefreshToken, error) {
+	var token RefreshToken
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		found, err := getBoltDoc(tx, boltRefreshTokensBucket, []byte(hash), &token)
+		if err != nil {
+			return err
+		}
+		if !found || token.Used || token.Revoked {
+			return errRefreshTokenNotFound
+		}
+		used := token
+		used.Used = true
+		return putBoltDoc(tx, boltRefreshTokensBucket, []byte(hash), used)
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	// Return the state before the update, same as the MongoDB store
+	return token, nil
+}
+
+func (s *boltRefreshTokenStore) Find(_ context.Context, hash string) (RefreshToken, error) {
+	var token RefreshToken
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found, err := getBoltDoc(tx, boltRefreshTokensBucket, []byte(hash), &token)
+		if err == nil && !found {
+			return errRefreshTokenNotFound
+		}
+		return err
+	})
+	return token, err
+}
+
+func (s *boltRefreshTokenStore) RevokeFamily(_ context.Context, familyID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateBoltRefreshTokens(tx,
+			func(token RefreshToken) bool { return token.FamilyID == familyID },
+			func(token *RefreshToken) { token.Revoked = true },
+		)
+	})
+}
+
+func (s *boltRefreshTokenStore) RevokeByPhone(_ context.Context, phone, exceptFamily string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateBoltRefreshTokens(tx,
+			func(token RefreshToken) bool {
+				return token.Phone == phone && (exceptFamily == "" || token.FamilyID != exceptFamily)
+			},
+			func(token *RefreshToken) { token.Revoked = true },
+		)
+	})
+}
+
+func (s *boltRefreshTokenStore) ChangePhone(_ context.Context, oldPhone, newPhone string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateBoltRefreshTokens(tx,
+			func(token RefreshToken) bool { return token.Phone == oldPhone },
+			func(token *RefreshToken) { token.Phone = newPhone },
+		)
+	})
+}
+
+func (s *boltRefreshTokenStore) DeleteByPhone(_ context.Context, phone string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return updateBoltRefreshTokens(tx, func(token RefreshToken) bool { return token.Phone == phone }, nil)
+	})
+}
+
+// boltRevokedTokenStore хранит отозванные токены в бакете revoked_tokens с ключом по jti.
+type boltRevokedTokenStore struct {
+	db *bolt.DB
+}
+
+func (s *boltRevokedTokenStore) Revoke(_ context.Context, jti string, expiresAt time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltRevokedTokensBucket).Get([]byte(jti)) != nil {
+			return nil
+		}
+		return putBoltDoc(tx, boltRevokedTokensBucket, []byte(jti), RevokedToken{JTI: jti, ExpiresAt: expiresAt, RevokedAt: time.Now()})
+	})
+}
+
+func (s *boltRevokedTokenStore) IsRevoked(_ context.Context, jti string) (bool, error) {
+	revoked := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		revoked = tx.Bucket(boltRevokedTokensBucket).Get([]byte(jti)) != nil
+		return nil
+	})
+	return revoked, err
+}
+
+// boltOTPStore хранит коды в бакете otp_codes с ключом otpKey.
+type boltOTPStore struct {
+	db *bolt.DB
+}
+
+func (s *boltOTPStore) Find(_ context.Context, phone, purpose string) (OTPCode, error) {
+	var code OTPCode
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found, err := getBoltDoc(tx, boltOTPCodesBucket, []byte(otpKey(phone, purpose)), &code)
+		if err == nil && !found {
+			return errOTPNotFound
+		}
+		return err
+	})
+	return code, err
+}
+
+func (s *boltOTPStore) Save(_ context.Context, code OTPCode) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltDoc(tx, boltOTPCodesBucket, []byte(otpKey(code.Phone, code.Purpose)), code)
+	})
+}
+
+func (s *boltOTPStore) IncAttempts(_ context.Context, phone, purpose string) error {
+	key := []byte(otpKey(phone, purpose))
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var code OTPCode
+		found, err := getBoltDoc(tx, boltOTPCodesBucket, key, &code)
+		if err != nil || !found {
+			return err
+		}
+		code.Attempts++
+		return putBoltDoc(tx, boltOTPCodesBucket, key, code)
+	})
+}
+
+func (s *boltOTPStore) Delete(_ context.Context, phone, purpose, codeHash string) (bool, error) {
+	key := []byte(otpKey(phone, purpose))
+	deleted := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var code OTPCode
+		found, err := getBoltDoc(tx, boltOTPCodesBucket, key, &code)
+		if err != nil || !found || code.CodeHash != codeHash {
+			return err
+		}
+		deleted = true
+		return tx.Bucket(boltOTPCodesBucket).Delete(key)
+	})
+	return deleted, err
+}
+
+// boltLoginAttemptStore хранит счетчики в бакете login_attempts с ключом по ключу счетчика.
+type boltLoginAttemptStore struct {
+	db *bolt.DB
+}
+
+func (s *boltLoginAttemptStore) Find(_ context.Context, keys ...string) ([]LoginAttempt, error) {
+	var attempts []LoginAttempt
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			var attempt LoginAttempt
+			found, err := getBoltDoc(tx, boltLoginAttemptsBucket, []byte(key), &attempt)
+			if err != nil {
+				return err
+			}
+			if found {
+				attempts = append(attempts, attempt)
+			}
+		}
+		return nil
+	})
+	return attempts, err
+}
+
+func (s *boltLoginAttemptStore) RecordFailure(_ context.Context, key string, since, at, expiresAt time.Time) (LoginAttempt, error) {
+	attempt := LoginAttempt{Key: key}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := getBoltDoc(tx, boltLoginAttemptsBucket, []byte(key), &attempt); err != nil {
+			return err
+		}
+		if attempt.LastFailure.Before(since) {
+			attempt.Failures = 0
+		}
+		attempt.Failures++
+		attempt.LastFailure = at
+		attempt.ExpiresAt = expiresAt
+		return putBoltDoc(tx, boltLoginAttemptsBucket, []byte(key), attempt)
+	})
+	return attempt, err
+}
+
+func (s *boltLoginAttemptStore) Lock(_ context.Context, key string, until time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var attempt LoginAttempt
+		found, err := getBoltDoc(tx, boltLoginAttemptsBucket, []byte(key), &attempt)
+		if err != nil || !found {
+			return err
+		}
+		attempt.LockedUntil = until
+		return putBoltDoc(tx, boltLoginAttemptsBucket, []byte(key), attempt)
+	})
+}
+
+func (s *boltLoginAttemptStore) Reset(_ context.Context, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltLoginAttemptsBucket).Delete([]byte(key))
+	})
+}
+
+// boltAPIKeyStore хранит API-ключи в бакете api_keys с ключом по hex-идентификатору.
+// Бакет api_keys_by_hash отображает хеш ключа в идентификатор.
+type boltAPIKeyStore struct {
+	db *bolt.DB
+}
+
+// getBoltAPIKey читает ключ внутри транзакции, если он существует и принадлежит пользователю.
+func getBoltAPIKey(tx *bolt.Tx, id, userID primitive.ObjectID) (APIKey, error) {
+	var key APIKey
+	found, err := getBoltDoc(tx, boltAPIKeysBucket, []byte(id.Hex()), &key)
+	if err != nil {
+		return APIKey{}, err
+	}
+	if !found || key.UserID != userID {
+		return APIKey{}, errAPIKeyNotFound
+	}
+	return key, nil
+}
+
+// deleteBoltAPIKey удаляет ключ вместе с записью индекса хешей.
+func deleteBoltAPIKey(tx *bolt.Tx, key APIKey) error {
+	if err := tx.Bucket(boltAPIKeysHashBucket).Delete([]byte(key.KeyHash)); err != nil {
+		return err
+	}
+	return tx.Bucket(boltAPIKeysBucket).Delete([]byte(key.ID.Hex()))
+}
+
+func (s *boltAPIKeyStore) Create(_ context.Context, key APIKey) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltAPIKeysHashBucket).Put([]byte(key.KeyHash), []byte(key.ID.Hex())); err != nil {
+			return err
+		}
+		return putBoltDoc(tx, boltAPIKeysBucket, []byte(key.ID.Hex()), key)
+	})
+}
+
+func (s *boltAPIKeyStore) FindByHash(_ context.Context, hash string) (APIKey, error) {
+	var key APIKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltAPIKeysHashBucket).Get([]byte(hash))
+		if id == nil {
+			return errAPIKeyNotFound
+		}
+		found, err := getBoltDoc(tx, boltAPIKeysBucket, id, &key)
+		if err == nil && !found {
+			return errAPIKeyNotFound
+		}
+		return err
+	})
+	return key, err
+}
+
+// listBoltAPIKeys возвращает ключи пользователя в порядке создания.
+func listBoltAPIKeys(tx *bolt.Tx, userID primitive.ObjectID) ([]APIKey, error) {
+	keys := []APIKey{}
+	// Keys are hex ObjectIDs, so the cursor walks API keys in creation order
+	err := tx.Bucket(boltAPIKeysBucket).ForEach(func(_, data []byte) error {
+		var key APIKey
+		if err := bson.Unmarshal(data, &key); err != nil {
+			return err
+		}
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (s *boltAPIKeyStore) ListByUser(_ context.Context, userID primitive.ObjectID) ([]APIKey, error) {
+	var keys []APIKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		keys, err = listBoltAPIKeys(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *boltAPIKeyStore) Rename(_ context.Context, id, userID primitive.ObjectID, name string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, err := getBoltAPIKey(tx, id, userID)
+		if err != nil {
+			return err
+		}
+		key.Name = name
+		return putBoltDoc(tx, boltAPIKeysBucket, []byte(id.Hex()), key)
+	})
+}
+
+func (s *boltAPIKeyStore) Touch(_ context.Context, id primitive.ObjectID, at time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var key APIKey
+		found, err := getBoltDoc(tx, boltAPIKeysBucket, []byte(id.Hex()), &key)
+		if err != nil || !found {
+			return err
+		}
+		key.LastUsedAt = at
+		return putBoltDoc(tx, boltAPIKeysBucket, []byte(id.Hex()), key)
+	})
+}
+
+func (s *boltAPIKeyStore) Delete(_ context.Context, id, userID primitive.ObjectID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, err := getBoltAPIKey(tx, id, userID)
+		if err != nil {
+			return err
+		}
+		return deleteBoltAPIKey(tx, key)
+	})
+}
+
+func (s *boltAPIKeyStore) DeleteByUser(_ context.Context, userID primitive.ObjectID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		keys, err := listBoltAPIKeys(tx, userID)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := deleteBoltAPIKey(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// boltWebAuthnCredentialStore хранит ключи в бакете webauthn_credentials с ключом по hex-идентификатору.
+// Бакет webauthn_credentials_by_credential_id отображает credential ID в идентификатор
+// и обеспечивает его уникальность.
+type boltWebAuthnCredentialStore struct {
+	db *bolt.DB
+}
+
+// listBoltWebAuthnCredentials возвращает ключи пользователя в порядке регистрации.
+func listBoltWebAuthnCredentials(tx *bolt.Tx, userID primitive.ObjectID) ([]WebAuthnCredential, error) {
+	credentials := []WebAuthnCredential{}
+	// Keys are hex ObjectIDs, so the cursor walks credentials in registration order
+	err := tx.Bucket(boltWebAuthnCredentialsBucket).ForEach(func(_, data []byte) error {
+		var credential WebAuthnCredential
+		if err := bson.Unmarshal(data, &credential); err != nil {
+			return err
+		}
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+		return nil
+	})
+	return credentials, err
+}
+
+// deleteBoltWebAuthnCredential удаляет ключ вместе с записью индекса credential ID.
+func deleteBoltWebAuthnCredential(tx *bolt.Tx, credential WebAuthnCredential) error {
+	if err := tx.Bucket(boltWebAuthnCredentialIDBucket).Delete(credential.CredentialID); err != nil {
+		return err
+	}
+	return tx.Bucket(boltWebAuthnCredentialsBucket).Delete([]byte(credential.ID.Hex()))
+}
+
+func (s *boltWebAuthnCredentialStore) Create(_ context.Context, credential WebAuthnCredential) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(boltWebAuthnCredentialIDBucket)
+		if index.Get(credential.CredentialID) != nil {
+			return errWebAuthnCredentialExists
+		}
+		if err := index.Put(credential.CredentialID, []byte(credential.ID.Hex())); err != nil {
+			return err
+		}
+		return putBoltDoc(tx, boltWebAuthnCredentialsBucket, []byte(credential.ID.Hex()), credential)
+	})
+}
+
+func (s *boltWebAuthnCredentialStore) ListByUser(_ context.Context, userID primitive.ObjectID) ([]WebAuthnCredential, error) {
+	var credentials []WebAuthnCredential
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		credentials, err = listBoltWebAuthnCredentials(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (s *boltWebAuthnCredentialStore) RecordUse(_ context.Context, credentialID []byte, signCount uint32, backupState bool, at time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltWebAuthnCredentialIDBucket).Get(credentialID)
+		if id == nil {
+			return nil
+		}
+		var credential WebAuthnCredential
+		found, err := getBoltDoc(tx, boltWebAuthnCredentialsBucket, id, &credential)
+		if err != nil || !found {
+			return err
+		}
+		credential.SignCount = signCount
+		credential.BackupState = backupState
+		credential.LastUsedAt = at
+		return putBoltDoc(tx, boltWebAuthnCredentialsBucket, bytes.Clone(id), credential)
+	})
+}
+
+func (s *boltWebAuthnCredentialStore) Delete(_ context.Context, id, userID primitive.ObjectID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var credential WebAuthnCredential
+		found, err := getBoltDoc(tx, boltWebAuthnCredentialsBucket, []byte(id.Hex()), &credential)
+		if err != nil {
+			return err
+		}
+		if !found || credential.UserID != userID {
+			return errWebAuthnCredentialNotFound
+		}
+		return deleteBoltWebAuthnCredential(tx, credential)
+	})
+}
+
+func (s *boltWebAuthnCredentialStore) DeleteByUser(_ context.Context, userID primitive.ObjectID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		credentials, err := listBoltWebAuthnCredentials(tx, userID)
+		if err != nil {
+			return err
+		}
+		for _, credential := range credentials {
+			if err := deleteBoltWebAuthnCredential(tx, credential); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// boltWebAuthnCeremonyStore хранит церемонии в бакете webauthn_ceremonies с ключом по challenge.
+type boltWebAuthnCeremonyStore struct {
+	db *bolt.DB
+}
+
+func (s *boltWebAuthnCeremonyStore) Save(_ context.Context, ceremony WebAuthnCeremony) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltDoc(tx, boltWebAuthnCeremoniesBucket, []byte(ceremony.Challenge), ceremony)
+	})
+}
+
+func (s *boltWebAuthnCeremonyStore) Take(_ context.Context, purpose, challenge string, now time.Time) (WebAuthnCeremony, error) {
+	var ceremony WebAuthnCeremony
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		found, err := getBoltDoc(tx, boltWebAuthnCeremoniesBucket, []byte(challenge), &ceremony)
+		if err != nil {
+			return err
+		}
+		if !found || ceremony.Purpose != purpose || !now.Before(ceremony.ExpiresAt) {
+			return errWebAuthnCeremonyNotFound
+		}
+		return tx.Bucket(boltWebAuthnCeremoniesBucket).Delete([]byte(challenge))
+	})
+	if err != nil {
+		return WebAuthnCeremony{}, err
+	}
+	return ceremony, nil
+}
+
+// boltOAuthClientStore хранит клиентов в бакете oauth_clients с ключом по client_id.
+type boltOAuthClientStore struct {
+	db *bolt.DB
+}
+
+func (s *boltOAuthClientStore) Create(_ context.Context, client OAuthClient) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltDoc(tx, boltOAuthClientsBucket, []byte(client.ClientID), client)
+	})
+}
+
+func (s *boltOAuthClientStore) FindByClientID(_ context.Context, clientID string) (OAuthClient, error) {
+	var client OAuthClient
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found, err := getBoltDoc(tx, boltOAuthClientsBucket, []byte(clientID), &client)
+		if err == nil && !found {
+			return errInvalidClient
+		}
+		return err
+	})
+	return client, err
+}
+
+// boltAuthorizationCodeStore хранит коды в бакете oauth_codes с ключом по хешу.
+type boltAuthorizationCodeStore struct {
+	db *bolt.DB
+}
+
+func (s *boltAuthorizationCodeStore) Create(_ context.Context, code AuthorizationCode) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putBoltDoc(tx, boltOAuthCodesBucket, []byte(code.Hash), code)
+	})
+}
+
+func (s *boltAuthorizationCodeStore) Use(_ context.Context, hash, clientID string) (AuthorizationCode, error) {
+	var code AuthorizationCode
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		found, err := getBoltDoc(tx, boltOAuthCodesBucket, []byte(hash), &code)
+		if err != nil {
+			return err
+		}
+		if !found || code.ClientID != clientID || code.Used {
+			return errInvalidGrant
+		}
+		used := code
+		used.Used = true
+		return putBoltDoc(tx, boltOAuthCodesBucket, []byte(hash), used)
+	})
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+	return code, nil
+}
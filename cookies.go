@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessTokenCookie  = "jwt_token"
+	refreshTokenCookie = "refresh_token"
+	csrfTokenCookie    = "csrf_token"
+
+	authModeHeader  = "X-Auth-Mode"
+	authModeCookie  = "cookie"
+	csrfTokenHeader = "X-CSRF-Token"
+)
+
+var (
+	authCookieDomain   = ""
+	authCookieSameSite = http.SameSiteStrictMode
+	authCookieSecure   = true
+)
+
+func initAuthCookies() {
+	authCookieDomain = config.AuthCookieDomain
+	authCookieSecure = config.AuthCookieSecure
+
+	switch sameSite := config.AuthCookieSameSite; strings.ToLower(sameSite) {
+	case "", "strict":
+		authCookieSameSite = http.SameSiteStrictMode
+	case "lax":
+		authCookieSameSite = http.SameSiteLaxMode
+	case "none":
+		authCookieSameSite = http.SameSiteNoneMode
+	default:
+		log.Fatalf("unknown AUTH_COOKIE_SAMESITE %q", sameSite)
+	}
+}
+
+// cookieModeRequested сообщает, что клиент просит выдать токены в cookie, а не в теле ответа.
+func cookieModeRequested(c *gin.Context) bool {
+	return strings.EqualFold(c.GetHeader(authModeHeader), authModeCookie)
+}
+
+// setAuthCookie выставляет cookie с общими для всех cookie аутентификации атрибутами.
+func setAuthCookie(c *gin.Context, name, value string, maxAge int, httpOnly bool) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   authCookieDomain,
+		MaxAge:   maxAge,
+		Secure:   authCookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: authCookieSameSite,
+	})
+}
+
+// setAccessTokenCookie выставляет HttpOnly cookie с access-токеном.
+func setAccessTokenCookie(c *gin.Context, token string) {
+	setAuthCookie(c, accessTokenCookie, token, int(accessTokenTTL.Seconds()), true)
+}
+
+// setAuthCookies выставляет cookie с access- и refresh-токенами и новый CSRF-токен,
+// который клиент должен повторять в заголовке X-CSRF-Token. Возвращает CSRF-токен.
+func setAuthCookies(c *gin.Context, token, refreshToken string) (string, error) {
+	csrfToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	setAccessTokenCookie(c, token)
+	setAuthCookie(c, refreshTokenCookie, refreshToken, int(refreshTokenTTL.Seconds()), true)
+	// The CSRF cookie must stay readable by scripts so they can echo it in the header
+	setAuthCookie(c, csrfTokenCookie, csrfToken, int(refreshTokenTTL.Seconds()), false)
+	return csrfToken, nil
+}
+
+// clearAuthCookies удаляет все cookie аутентификации.
+func clearAuthCookies(c *gin.Context) {
+	setAuthCookie(c, accessTokenCookie, "", -1, true)
+	setAuthCookie(c, refreshTokenCookie, "", -1, true)
+	setAuthCookie(c, csrfTokenCookie, "", -1, false)
+}
+
+// checkCSRF проверяет изменяющие запросы, аутентифицированные cookie, по схеме double-submit:
+// значение заголовка X-CSRF-Token должно совпадать с cookie csrf_token. Вызывается только там,
+// где токен действительно взят из cookie: запросы с заголовком Authorization браузер сам не подделает.
+// При несовпадении отвечает 403 и возвращает false.
+func checkCSRF(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	cookie, err := c.Cookie(csrfTokenCookie)
+	header := c.GetHeader(csrfTokenHeader)
+	if err != nil || cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+		c.Abort()
+		return false
+	}
+	return true
+}
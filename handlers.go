@@ -2,86 +2,246 @@ package main
 
 import (
 	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 )
 
+// Handler обслуживает HTTP-запросы поверх хранилищ пользователей, постов и данных аутентификации.
+// Хранилища передаются через NewHandler, чтобы обработчики можно было проверять без базы данных.
+type Handler struct {
+	Stores
+}
+
+// NewHandler создает обработчики поверх набора хранилищ.
+func NewHandler(stores Stores) *Handler {
+	return &Handler{Stores: stores}
+}
+
 // Register godoc
 //
 //	@Summary		Register a new user
-//	@Description	Register a new user with a unique phone number
+//	@Description	Register a new user with a unique phone number. The phone is normalized to E.164 and stays unverified until the SMS code is confirmed.
 //	@Tags			User
 //	@Accept			json
 //	@Produce		json
 //	@Param			user	body		User					true	"User details"
 //	@Success		200		{object}	map[string]interface{}	"User registered successfully"
-//	@Failure		400		{object}	map[string]string		"Invalid input"
+//	@Failure		400		{object}	map[string]interface{}	"Invalid input, phone number or password policy violations"
+//	@Failure		409		{object}	map[string]string		"Phone is already registered"
 //	@Failure		500		{object}	map[string]string		"Error creating user"
 //	@Router			/register [post]
-func Register(c *gin.Context) {
+func (h *Handler) Register(c *gin.Context) {
 	var user User
 	if err := c.BindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	phone, err := normalizePhone(user.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+		return
+	}
+
+	if violations := passwordPolicy.Check(user.Password, phone); len(violations) > 0 {
+		respondPasswordViolations(c, violations)
+		return
+	}
+
 	hashedPassword, _ := HashPassword(user.Password)
+	user.ID = primitive.NewObjectID()
+	user.Phone = phone
 	user.Password = hashedPassword
-
-	_, err := userCollection.InsertOne(context.TODO(), user)
+	// Roles and account state are managed by administrators only
+	user.Role = RoleUser
+	user.Disabled = false
+	user.PhoneVerified = false
+
+	err = h.users.Create(context.Background(), user)
+	if errors.Is(err, errPhoneTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Phone is already registered"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user"})
 		return
 	}
 
+	// The account stays unverified until the code sent by SMS is confirmed
+	if err := h.issueOTP(context.Background(), user.Phone, otpPurposeVerifyPhone); err != nil {
+		log.Println("Error sending phone confirmation code:", err)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message":  "User registered successfully",
-		"userData": user,
+		"message":  "User registered successfully, confirm the phone number with the code sent by SMS",
+		"userData": userSummary(user),
 	})
 }
 
 // Login godoc
 //
 //	@Summary		Login an existing user
-//	@Description	Login an existing user with phone number and password
+//	@Description	Login an existing user with phone number and password. Users with TOTP enabled get an mfa_token to exchange at /login/mfa instead of tokens. With "X-Auth-Mode: cookie" the tokens are set as HttpOnly cookies and the response carries a CSRF token to send in X-CSRF-Token.
 //	@Tags			User
 //	@Accept			json
 //	@Produce		json
-//	@Param			user	body		User					true	"User credentials"
-//	@Success		200		{object}	map[string]interface{}	"User logged in successfully"
-//	@Failure		400		{object}	map[string]string		"Invalid input"
-//	@Failure		401		{object}	map[string]string		"Invalid phone or password"
-//	@Failure		500		{object}	map[string]string		"Error generating token"
+//	@Param			user			body		User					true	"User credentials"
+//	@Param			X-Device-Name	header		string					false	"Device name shown in the session list"
+//	@Param			X-Auth-Mode		header		string					false	"Set to cookie to receive the tokens as cookies"
+//	@Success		200				{object}	map[string]interface{}	"Access and refresh tokens, or a CSRF token in cookie mode"
+//	@Failure		400				{object}	map[string]string		"Invalid input"
+//	@Failure		401				{object}	map[string]string		"Invalid phone or password"
+//	@Failure		403				{object}	map[string]string		"Account is disabled or phone is not verified"
+//	@Failure		429				{object}	map[string]string		"Too many failed login attempts"
+//	@Failure		500				{object}	map[string]string		"Error generating token"
 //	@Router			/login [post]
-func Login(c *gin.Context) {
+func (h *Handler) Login(c *gin.Context) {
 	var user User
 	if err := c.BindJSON(&user); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var foundUser User
-	err := userCollection.FindOne(context.TODO(), bson.M{"phone": user.Phone}).Decode(&foundUser)
+	phone, err := normalizePhone(user.Phone)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid phone or password"})
+		// Keep the raw value so malformed phones are throttled too
+		phone = user.Phone
+	}
+	phoneKey, ipKey := phoneAttemptKey(phone), ipAttemptKey(c.ClientIP())
+
+	wait, err := h.loginBlockedFor(context.Background(), phoneKey, ipKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
 		return
 	}
 
-	if !CheckPasswordHash(user.Password, foundUser.Password) {
+	foundUser, err := h.users.FindByPhone(context.Background(), phone)
+	if err != nil {
+		// Spend the same time as a real comparison so unknown phones cannot be told apart
+		foundUser.Password = dummyPasswordHash
+	}
+
+	if !CheckPasswordHash(user.Password, foundUser.Password) || err != nil {
+		lockedUntil, err := h.recordLoginFailure(context.Background(), phoneKey, accountLockoutThreshold)
+		if err != nil {
+			log.Println("Error recording failed login:", err)
+		} else if !lockedUntil.IsZero() && !foundUser.ID.IsZero() {
+			notifyAccountLocked(context.Background(), foundUser, lockedUntil)
+		}
+		if _, err := h.recordLoginFailure(context.Background(), ipKey, ipLockoutThreshold); err != nil {
+			log.Println("Error recording failed login:", err)
+		}
+
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid phone or password"})
 		return
 	}
-	token, err := GenerateJWT(user.Phone)
+	if err := h.resetLoginFailures(context.Background(), phoneKey); err != nil {
+		log.Println("Error resetting failed logins:", err)
+	}
+	// Upgrade hashes made with an outdated algorithm or cost while the plain password is at hand
+	if passwordNeedsRehash(foundUser.Password) {
+		if hashedPassword, err := HashPassword(user.Password); err == nil {
+			// Skip the upgrade if the password was changed in the meantime
+			if _, err := h.users.ReplacePassword(context.Background(), foundUser.ID, foundUser.Password, hashedPassword); err != nil {
+				log.Println("Error upgrading password hash:", err)
+			}
+		}
+	}
+	if foundUser.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+	if !foundUser.PhoneVerified && unverifiedLoginPolicy == unverifiedLoginDeny {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Phone number is not verified"})
+		return
+	}
+
+	h.completeLogin(c, foundUser)
+}
+
+// Refresh godoc
+//
+//	@Summary		Rotate a refresh token
+//	@Description	Exchange a refresh token for a new access token and a new refresh token. Presenting an already used refresh token revokes the whole token family. In cookie mode the refresh token is read from the refresh_token cookie and new cookies are set.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			request			body		RefreshRequest		false	"Refresh token, unless sent as a cookie"
+//	@Param			X-CSRF-Token	header		string				false	"CSRF token, required when the refresh token is sent as a cookie"
+//	@Success		200				{object}	map[string]string	"New access and refresh tokens, or a CSRF token in cookie mode"
+//	@Failure		400				{object}	map[string]string	"Invalid input"
+//	@Failure		401				{object}	map[string]string	"Invalid or reused refresh token"
+//	@Failure		403				{object}	map[string]string	"Account is disabled or invalid CSRF token"
+//	@Failure		500				{object}	map[string]string	"Error generating token"
+//	@Router			/token/refresh [post]
+func (h *Handler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	fromCookie := false
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// Browser clients in cookie mode send the refresh token as a cookie
+		cookie, cookieErr := c.Cookie(refreshTokenCookie)
+		if cookieErr != nil || cookie == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.RefreshToken, fromCookie = cookie, true
+		if !checkCSRF(c) {
+			return
+		}
+	}
+
+	current, refreshToken, err := h.rotateRefreshToken(context.Background(), req.RefreshToken)
+	if errors.Is(err, errRefreshTokenReused) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected, all sessions revoked"})
+		return
+	}
+	if errors.Is(err, errRefreshTokenInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token, "userData": user})
+	user, err := h.users.FindByPhone(context.Background(), current.Phone)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	token, err := GenerateJWT(user, current.FamilyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	if fromCookie || cookieModeRequested(c) {
+		csrfToken, err := setAuthCookies(c, token, refreshToken)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"csrf_token": csrfToken})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
 }
 
 //	@BasePath	/api/v1
@@ -89,15 +249,37 @@ func Login(c *gin.Context) {
 // Logout godoc
 //
 //	@Summary		Logout the current user
-//	@Description	Logout the current user by clearing the JWT token
+//	@Description	Logout the current user by revoking the presented JWT token. If a refresh token is sent in the body or as a cookie, its whole token family is revoked as well.
 //	@Tags			User
 //	@Accept			json
 //	@Produce		json
-//	@Success		200	{object}	map[string]string	"Logged out successfully"
+//	@Security		ApiKeyAuth
+//	@Param			request	body		RefreshRequest		false	"Refresh token to revoke"
+//	@Success		200		{object}	map[string]string	"Logged out successfully"
+//	@Failure		401		{object}	map[string]string	"Invalid token"
+//	@Failure		500		{object}	map[string]string	"Failed to revoke token"
 //	@Router			/logout [post]
-func Logout(c *gin.Context) {
-	// Clear the JWT token from the client (for example, from cookies)
-	c.SetCookie("jwt_token", "", -1, "/", "localhost", false, true)
+func (h *Handler) Logout(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+	if err := h.revokedTokens.Revoke(context.Background(), claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+
+	// Refresh token is optional, revoke its family if the client sent one
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		req.RefreshToken, _ = c.Cookie(refreshTokenCookie)
+	}
+	if req.RefreshToken != "" {
+		if err := h.revokeRefreshToken(context.Background(), req.RefreshToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+			return
+		}
+	}
+
+	// Clear the tokens from the client if it uses cookie mode
+	clearAuthCookies(c)
 
 	// Respond with success message
 	c.JSON(http.StatusOK, gin.H{
@@ -107,50 +289,135 @@ func Logout(c *gin.Context) {
 
 // UpdatePassword godoc
 //
-//	@Summary		Update user password
-//	@Description	Update the password for the authenticated user
+//	@Summary		Change password
+//	@Description	Change the password of the authenticated user. The current password is required and all other sessions are terminated.
 //	@Tags			User
 //	@Accept			json
 //	@Produce		json
-//	@Param			id		path		string				true	"User ID"
-//	@Param			user	body		User				true	"User details"
-//	@Success		200		{object}	map[string]string	"Profile updated successfully"
-//	@Failure		400		{object}	map[string]string	"Invalid ID or input"
-//	@Failure		500		{object}	map[string]string	"Failed to update profile info"
-//	@Router			/users/{id}/password [put]
-func UpdatePassword(c *gin.Context) {
-	id := c.Param("id")
-	objID, err := primitive.ObjectIDFromHex(id)
+//	@Security		ApiKeyAuth
+//	@Param			request	body		ChangePasswordRequest	true	"Current and new password"
+//	@Success		200		{object}	map[string]string		"Password changed successfully"
+//	@Failure		400		{object}	map[string]interface{}	"Invalid input or password policy violations"
+//	@Failure		401		{object}	map[string]string		"Current password is incorrect"
+//	@Failure		500		{object}	map[string]string		"Failed to change password"
+//	@Router			/protected/password [post]
+func (h *Handler) UpdatePassword(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	user := currentUser(c)
+	if !CheckPasswordHash(req.CurrentPassword, user.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if violations := passwordPolicy.Check(req.NewPassword, user.Phone); len(violations) > 0 {
+		respondPasswordViolations(c, violations)
+		return
+	}
+
+	hashedPassword, err := HashPassword(req.NewPassword)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+	mustChange := false
+	_, err = h.users.Update(context.Background(), user.ID, UserUpdate{Password: &hashedPassword, MustChangePassword: &mustChange})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
 		return
 	}
 
-	var user User
-	if err := c.ShouldBindJSON(&user); err != nil {
+	// Keep the current session, sign out everywhere else
+	if err := h.revokeOtherSessions(context.Background(), user.Phone, claims.SessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed successfully"})
+}
+
+// UpdateProfile godoc
+//
+//	@Summary		Update profile
+//	@Description	Update the name and/or phone of the authenticated user. Omitted fields are left unchanged. A new phone must be confirmed by SMS and a new access token is returned.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			request	body		UpdateProfileRequest	true	"Profile fields to change"
+//	@Success		200		{object}	map[string]interface{}	"Profile updated successfully"
+//	@Failure		400		{object}	map[string]string		"Invalid input or phone number"
+//	@Failure		409		{object}	map[string]string		"Phone is already registered"
+//	@Failure		500		{object}	map[string]string		"Failed to update profile info"
+//	@Router			/protected/profile [patch]
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Ensure the profile belongs to the authenticated user
-	authorID := c.MustGet("userID").(primitive.ObjectID)
-	filter := bson.M{"_id": objID, "author_id": authorID}
+	update := UserUpdate{Name: req.Name}
+	phoneChanged := false
+	if req.Phone != nil {
+		phone, err := normalizePhone(*req.Phone)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+			return
+		}
+		if phone != claims.Phone {
+			verified := false
+			update.Phone = &phone
+			update.PhoneVerified = &verified
+			phoneChanged = true
+		}
+	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"name":     user.Name,
-			"phone":    user.Phone,
-			"password": user.Password,
-		},
+	if update.Name == nil && update.Phone == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update"})
+		return
 	}
 
-	result := userCollection.FindOneAndUpdate(context.Background(), filter, update)
-	if result.Err() != nil {
+	user, err := h.users.Update(context.Background(), currentUserID(c), update)
+	if errors.Is(err, errPhoneTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Phone is already registered"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile info"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Profile updated successfully"})
+	response := gin.H{"message": "Profile updated successfully", "userData": userSummary(user)}
+	if phoneChanged {
+		// Sessions are keyed by phone, move them and reissue the access token
+		if err := h.changeSessionsPhone(context.Background(), claims.Phone, user.Phone); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile info"})
+			return
+		}
+		token, err := GenerateJWT(user, claims.SessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+			return
+		}
+		if c.GetBool("auth_cookie") {
+			setAccessTokenCookie(c, token)
+		} else {
+			response["token"] = token
+		}
+
+		if err := h.issueOTP(context.Background(), user.Phone, otpPurposeVerifyPhone); err != nil {
+			log.Println("Error sending phone confirmation code:", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // UserProfile godoc
@@ -164,7 +431,7 @@ func UpdatePassword(c *gin.Context) {
 //	@Success		200	{object}	map[string]interface{}	"User profile"
 //	@Failure		404	{object}	map[string]string		"User Id is required or Failed to fetch user profile"
 //	@Router			/users/{id} [get]
-func UserProfile(c *gin.Context) {
+func (h *Handler) UserProfile(c *gin.Context) {
 	// Extract phone number from query parameter
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
@@ -173,9 +440,8 @@ func UserProfile(c *gin.Context) {
 		return
 	}
 
-	// Find user by phone number in MongoDB
-	var user User
-	err1 := userCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&user)
+	// Find user by ID
+	user, err1 := h.users.FindByID(context.Background(), objID)
 	if err1 != nil {
 		log.Println("Error fetching user profile:", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Failed to fetch user profile"})
@@ -201,7 +467,7 @@ func UserProfile(c *gin.Context) {
 //	@Failure		400		{object}	map[string]string	"Invalid input"
 //	@Failure		500		{object}	map[string]string	"Failed to create post"
 //	@Router			/posts [post]
-func CreatePost(c *gin.Context) {
+func (h *Handler) CreatePost(c *gin.Context) {
 	var post Post
 	if err := c.ShouldBindJSON(&post); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -210,10 +476,9 @@ func CreatePost(c *gin.Context) {
 
 	post.ID = primitive.NewObjectID()
 	post.Date = time.Now()
-	post.AuthorID = c.MustGet("userID").(primitive.ObjectID) // Assuming you have userID in context
+	post.AuthorID = currentUserID(c)
 
-	_, err := postCollection.InsertOne(context.Background(), post)
-	if err != nil {
+	if err := h.posts.Create(context.Background(), post); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create post"})
 		return
 	}
@@ -231,19 +496,12 @@ func CreatePost(c *gin.Context) {
 //	@Success		200	{array}		Post				"List of posts"
 //	@Failure		500	{object}	map[string]string	"Failed to fetch posts or parse posts"
 //	@Router			/posts [get]
-func GetPosts(c *gin.Context) {
-	cursor, err := postCollection.Find(context.Background(), bson.M{})
+func (h *Handler) GetPosts(c *gin.Context) {
+	posts, err := h.posts.List(context.Background())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch posts"})
 		return
 	}
-	defer cursor.Close(context.Background())
-
-	var posts []Post
-	if err = cursor.All(context.Background(), &posts); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse posts"})
-		return
-	}
 
 	c.JSON(http.StatusOK, posts)
 }
@@ -251,7 +509,7 @@ func GetPosts(c *gin.Context) {
 // UpdatePost godoc
 //
 //	@Summary		Update a post
-//	@Description	Update an existing post by ID for the authenticated user
+//	@Description	Update an existing post by ID for the authenticated user. Moderators may update any post.
 //	@Tags			Post
 //	@Accept			json
 //	@Produce		json
@@ -259,9 +517,10 @@ func GetPosts(c *gin.Context) {
 //	@Param			post	body		Post				true	"Post details"
 //	@Success		200		{object}	map[string]string	"Post updated successfully"
 //	@Failure		400		{object}	map[string]string	"Invalid post ID or input"
+//	@Failure		404		{object}	map[string]string	"Post not found"
 //	@Failure		500		{object}	map[string]string	"Failed to update post"
 //	@Router			/posts/{id} [put]
-func UpdatePost(c *gin.Context) {
+func (h *Handler) UpdatePost(c *gin.Context) {
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -275,21 +534,19 @@ func UpdatePost(c *gin.Context) {
 		return
 	}
 
-	// Ensure the post belongs to the authenticated user
-	authorID := c.MustGet("userID").(primitive.ObjectID)
-	filter := bson.M{"_id": objID, "author_id": authorID}
-
-	update := bson.M{
-		"$set": bson.M{
-			"title": post.Title,
-			"text":  post.Text,
-			"image": post.Image,
-			"date":  time.Now(),
-		},
+	// Ensure the post belongs to the authenticated user unless they may moderate posts
+	authorID := currentUserID(c)
+	if hasPermission(currentUser(c).Role, PermissionPostsModerate) {
+		authorID = primitive.NilObjectID
 	}
 
-	result := postCollection.FindOneAndUpdate(context.Background(), filter, update)
-	if result.Err() != nil {
+	post.Date = time.Now()
+	err = h.posts.Update(context.Background(), objID, authorID, post)
+	if errors.Is(err, errPostNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update post"})
 		return
 	}
@@ -300,16 +557,17 @@ func UpdatePost(c *gin.Context) {
 // DeletePost godoc
 //
 //	@Summary		Delete a post
-//	@Description	Delete an existing post by ID for the authenticated user
+//	@Description	Delete an existing post by ID for the authenticated user. Moderators may delete any post.
 //	@Tags			Post
 //	@Accept			json
 //	@Produce		json
 //	@Param			id	path		string				true	"Post ID"
 //	@Success		200	{object}	map[string]string	"Post deleted successfully"
 //	@Failure		400	{object}	map[string]string	"Invalid post ID"
+//	@Failure		404	{object}	map[string]string	"Post not found"
 //	@Failure		500	{object}	map[string]string	"Failed to delete post"
 //	@Router			/posts/{id} [delete]
-func DeletePost(c *gin.Context) {
+func (h *Handler) DeletePost(c *gin.Context) {
 	id := c.Param("id")
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -317,12 +575,18 @@ func DeletePost(c *gin.Context) {
 		return
 	}
 
-	// Ensure the post belongs to the authenticated user
-	authorID := c.MustGet("userID").(primitive.ObjectID)
-	filter := bson.M{"_id": objID, "author_id": authorID}
+	// Ensure the post belongs to the authenticated user unless they may moderate posts
+	authorID := currentUserID(c)
+	if hasPermission(currentUser(c).Role, PermissionPostsModerate) {
+		authorID = primitive.NilObjectID
+	}
 
-	result := postCollection.FindOneAndDelete(context.Background(), filter)
-	if result.Err() != nil {
+	err = h.posts.Delete(context.Background(), objID, authorID)
+	if errors.Is(err, errPostNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete post"})
 		return
 	}
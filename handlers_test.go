@@ -0,0 +1,546 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRegister(t *testing.T) {
+	r, h := newTestServer(t)
+
+	w := doJSON(r, http.MethodPost, "/register", "", gin.H{"name": "Alice", "phone": "8 701 123 45 67", "password": testPassword, "role": RoleAdmin})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		UserData UserSummary `json:"userData"`
+	}
+	decodeBody(t, w, &body)
+	if body.UserData.Phone != "+77011234567" {
+		t.Errorf("phone = %q, want normalized +77011234567", body.UserData.Phone)
+	}
+
+	user, err := h.users.FindByPhone(context.Background(), "+77011234567")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != RoleUser || user.PhoneVerified {
+		t.Errorf("role %q, verified %v: want a plain unverified user", user.Role, user.PhoneVerified)
+	}
+	if user.Password == testPassword || !CheckPasswordHash(testPassword, user.Password) {
+		t.Error("password is not stored as a hash")
+	}
+	if testSMS.last(user.Phone) == "" {
+		t.Error("no confirmation code sent")
+	}
+
+	// A breached password list in the Have I Been Pwned format with the SHA-1 of "Summer2024Pass"
+	listPath := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(listPath, []byte("3341C9DC6F6A5843896B6C540D1522B3AADB7308:42\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := loadBreachedPasswords(listPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breachedPasswords, passwordPolicy.CheckBreached = list, true
+	t.Cleanup(func() {
+		breachedPasswords = nil
+		passwordPolicy.CheckBreached = false
+	})
+
+	tests := []struct {
+		name  string
+		body  gin.H
+		want  int
+		rules []string
+	}{
+		{name: "duplicate phone", body: gin.H{"phone": "+7 701 123 4567", "password": testPassword}, want: http.StatusConflict},
+		{name: "invalid phone", body: gin.H{"phone": "12", "password": testPassword}, want: http.StatusBadRequest},
+		{
+			name:  "weak password",
+			body:  gin.H{"phone": "+77011234568", "password": "short"},
+			want:  http.StatusBadRequest,
+			rules: []string{"min_length", "class_upper", "class_digit"},
+		},
+		{
+			name:  "too long password",
+			body:  gin.H{"phone": "+77011234568", "password": strings.Repeat("Aa1", 25)},
+			want:  http.StatusBadRequest,
+			rules: []string{"max_length"},
+		},
+		{
+			name:  "phone in password",
+			body:  gin.H{"phone": "+77011234568", "password": "Xx7011234568"},
+			want:  http.StatusBadRequest,
+			rules: []string{"no_phone"},
+		},
+		{
+			name:  "breached password",
+			body:  gin.H{"phone": "+77011234568", "password": "Summer2024Pass"},
+			want:  http.StatusBadRequest,
+			rules: []string{"breached"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A fresh server per case, /register accepts only a few attempts per IP
+			r, h := newTestServer(t)
+			createTestUser(t, h, "+77011234567", RoleUser)
+			w := doJSON(r, http.MethodPost, "/register", "", tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.rules == nil {
+				return
+			}
+			var body struct {
+				Violations []PasswordViolation `json:"violations"`
+			}
+			decodeBody(t, w, &body)
+			var rules []string
+			for _, violation := range body.Violations {
+				rules = append(rules, violation.Rule)
+			}
+			if strings.Join(rules, ",") != strings.Join(tt.rules, ",") {
+				t.Errorf("violated rules %v, want %v", rules, tt.rules)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+
+	w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": "87011234567", "password": testPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Token        string      `json:"token"`
+		RefreshToken string      `json:"refresh_token"`
+		UserData     UserSummary `json:"userData"`
+	}
+	decodeBody(t, w, &body)
+	if body.Token == "" || body.RefreshToken == "" || body.UserData.ID != user.ID {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+	sessions, err := h.sessions.ListActive(context.Background(), user.Phone)
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("sessions = %v, %v: want one session", sessions, err)
+	}
+	if w := doJSON(r, http.MethodGet, "/protected/", body.Token, nil); w.Code != http.StatusOK {
+		t.Errorf("token rejected: status %d", w.Code)
+	}
+
+	t.Run("unknown phone", func(t *testing.T) {
+		w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": "+77019999999", "password": testPassword})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want 401", w.Code)
+		}
+	})
+
+	t.Run("disabled account", func(t *testing.T) {
+		disabled := createTestUser(t, h, "+77011234570", RoleUser)
+		yes := true
+		if _, err := h.users.Update(context.Background(), disabled.ID, UserUpdate{Disabled: &yes}); err != nil {
+			t.Fatal(err)
+		}
+		w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": disabled.Phone, "password": testPassword})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status %d, want 403", w.Code)
+		}
+	})
+
+	t.Run("backoff after failures", func(t *testing.T) {
+		// A fresh server, failures of the other subtests count against the same client IP
+		r, h := newTestServer(t)
+		user := createTestUser(t, h, "+77011234567", RoleUser)
+		for i := 0; i < loginBackoffThreshold; i++ {
+			w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": user.Phone, "password": "Wrong123"})
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("attempt %d: status %d, want 401", i+1, w.Code)
+			}
+		}
+		w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": user.Phone, "password": testPassword})
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("status %d, Retry-After %q: want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("lockout after failures", func(t *testing.T) {
+		r, h := newTestServer(t)
+		user := createTestUser(t, h, "+77011234567", RoleUser)
+		for i := 0; i < accountLockoutThreshold; i++ {
+			if _, err := h.recordLoginFailure(context.Background(), phoneAttemptKey(user.Phone), accountLockoutThreshold); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// The lockout outlasts the backoff of the same number of failures
+		w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": user.Phone, "password": testPassword})
+		retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+		if w.Code != http.StatusTooManyRequests || time.Duration(retryAfter)*time.Second <= loginBackoff(accountLockoutThreshold) {
+			t.Errorf("status %d, Retry-After %ds: want 429 for the lockout of %s", w.Code, retryAfter, loginLockoutDuration)
+		}
+	})
+
+	t.Run("rehash after a parameter change", func(t *testing.T) {
+		for name, hasher := range map[string]PasswordHasher{
+			"bcrypt cost": bcryptHasher{cost: 5},
+			"algorithm":   argon2idHasher{memory: 1024, iterations: 1, parallelism: 1, saltLength: 16, keyLength: 32},
+		} {
+			t.Run(name, func(t *testing.T) {
+				r, h := newTestServer(t)
+				user := createTestUser(t, h, "+77011234567", RoleUser)
+				outdated, err := hasher.Hash(testPassword)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := h.users.Update(context.Background(), user.ID, UserUpdate{Password: &outdated}); err != nil {
+					t.Fatal(err)
+				}
+
+				loginTestUser(t, r, user.Phone)
+				stored, err := h.users.FindByID(context.Background(), user.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.Password == outdated || passwordNeedsRehash(stored.Password) || !CheckPasswordHash(testPassword, stored.Password) {
+					t.Errorf("hash %q was not upgraded to the current parameters", stored.Password)
+				}
+			})
+		}
+	})
+}
+
+// loginTestTokens входит паролем testPassword и возвращает access- и refresh-токены.
+func loginTestTokens(t *testing.T, r http.Handler, phone string) (string, string) {
+	t.Helper()
+	w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": phone, "password": testPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login %s: status %d, body %s", phone, w.Code, w.Body.String())
+	}
+	var body struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decodeBody(t, w, &body)
+	return body.Token, body.RefreshToken
+}
+
+// refreshTestTokens обменивает refresh-токен на новую пару токенов.
+func refreshTestTokens(r http.Handler, refreshToken string) (*httptest.ResponseRecorder, string, string) {
+	w := doJSON(r, http.MethodPost, "/token/refresh", "", gin.H{"refresh_token": refreshToken})
+	var body struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w, body.Token, body.RefreshToken
+}
+
+func TestRefresh(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	_, first := loginTestTokens(t, r, user.Phone)
+	otherToken, otherRefresh := loginTestTokens(t, r, user.Phone)
+
+	w, token, second := refreshTestTokens(r, first)
+	if w.Code != http.StatusOK || token == "" || second == "" || second == first {
+		t.Fatalf("refresh: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, http.MethodGet, "/protected/", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("refreshed token rejected: status %d", w.Code)
+	}
+
+	// Presenting the rotated token again means it leaked, the whole family goes
+	if w, _, _ := refreshTestTokens(r, first); w.Code != http.StatusUnauthorized {
+		t.Fatalf("reuse: status %d, want 401", w.Code)
+	}
+	if w, _, _ := refreshTestTokens(r, second); w.Code != http.StatusUnauthorized {
+		t.Errorf("latest token of the family: status %d, want 401", w.Code)
+	}
+	if w := doJSON(r, http.MethodGet, "/protected/", token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("access token of the family: status %d, want 401", w.Code)
+	}
+
+	// Other sessions of the user are not affected
+	if w := doJSON(r, http.MethodGet, "/protected/", otherToken, nil); w.Code != http.StatusOK {
+		t.Errorf("other session: status %d, want 200", w.Code)
+	}
+	if w, _, _ := refreshTestTokens(r, otherRefresh); w.Code != http.StatusOK {
+		t.Errorf("other session refresh: status %d, want 200", w.Code)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	token, refreshToken := loginTestTokens(t, r, user.Phone)
+
+	if w := doJSON(r, http.MethodPost, "/logout", token, gin.H{"refresh_token": refreshToken}); w.Code != http.StatusOK {
+		t.Fatalf("logout: status %d, body %s", w.Code, w.Body.String())
+	}
+	revoked, err := h.revokedTokens.IsRevoked(context.Background(), parseTestToken(t, token).Id)
+	if err != nil || !revoked {
+		t.Errorf("IsRevoked = %v, %v: want the jti revoked", revoked, err)
+	}
+	if w := doJSON(r, http.MethodGet, "/protected/", token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("access token after logout: status %d, want 401", w.Code)
+	}
+	if w, _, _ := refreshTestTokens(r, refreshToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token after logout: status %d, want 401", w.Code)
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	token, refreshToken := loginTestTokens(t, r, user.Phone)
+	otherToken, otherRefresh := loginTestTokens(t, r, user.Phone)
+
+	if w := doJSON(r, http.MethodPost, "/protected/sessions/revoke-all", token, nil); w.Code != http.StatusOK {
+		t.Fatalf("revoke-all: status %d, body %s", w.Code, w.Body.String())
+	}
+	stored, err := h.users.FindByID(context.Background(), user.ID)
+	if err != nil || stored.TokenVersion != user.TokenVersion+1 {
+		t.Fatalf("token version %d, %v: want %d", stored.TokenVersion, err, user.TokenVersion+1)
+	}
+	for name, token := range map[string]string{"current": token, "other": otherToken} {
+		claims := parseTestToken(t, token)
+		// Only the version check must reject the token, not the terminated session
+		if err := h.sessions.Create(context.Background(), Session{ID: sessionObjectID(claims.SessionID), Phone: user.Phone}); err != nil {
+			t.Fatal(err)
+		}
+		if w := doJSON(r, http.MethodGet, "/protected/", token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s access token: status %d, want 401", name, w.Code)
+		}
+	}
+	for name, refreshToken := range map[string]string{"current": refreshToken, "other": otherRefresh} {
+		if w, _, _ := refreshTestTokens(r, refreshToken); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s refresh token: status %d, want 401", name, w.Code)
+		}
+	}
+
+	if w := doJSON(r, http.MethodGet, "/protected/", loginTestUser(t, r, user.Phone), nil); w.Code != http.StatusOK {
+		t.Errorf("new login: status %d, want 200", w.Code)
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	yes, secret, recovery := true, "JBSWY3DPEHPK3PXP", "abcde-fghij"
+	update := UserUpdate{TOTPEnabled: &yes, TOTPSecret: &secret, RecoveryCodes: &[]string{hashRecoveryCode(recovery)}}
+	if _, err := h.users.Update(context.Background(), user.ID, update); err != nil {
+		t.Fatal(err)
+	}
+
+	mfaToken := func(t *testing.T) string {
+		t.Helper()
+		w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": user.Phone, "password": testPassword})
+		var body struct {
+			MFAToken string `json:"mfa_token"`
+		}
+		decodeBody(t, w, &body)
+		if w.Code != http.StatusOK || body.MFAToken == "" {
+			t.Fatalf("status %d, body %s: want an mfa_token", w.Code, w.Body.String())
+		}
+		return body.MFAToken
+	}
+	totpCode := func(t *testing.T) string {
+		t.Helper()
+		code, err := totp.GenerateCodeCustom(secret, time.Now(),
+			totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	t.Run("not an access token", func(t *testing.T) {
+		if w := doJSON(r, http.MethodGet, "/protected/", mfaToken(t), nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d, want 401", w.Code)
+		}
+	})
+
+	t.Run("single use", func(t *testing.T) {
+		token := mfaToken(t)
+		if w := doJSON(r, http.MethodPost, "/login/mfa", "", gin.H{"mfa_token": token, "code": totpCode(t)}); w.Code != http.StatusOK {
+			t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+		}
+		if w := doJSON(r, http.MethodPost, "/login/mfa", "", gin.H{"mfa_token": token, "code": recovery}); w.Code != http.StatusUnauthorized {
+			t.Errorf("reused token: status %d, want 401", w.Code)
+		}
+	})
+
+	t.Run("invalidated after failures", func(t *testing.T) {
+		token := mfaToken(t)
+		for i := 0; i < mfaMaxAttempts; i++ {
+			if w := doJSON(r, http.MethodPost, "/login/mfa", "", gin.H{"mfa_token": token, "code": "000000"}); w.Code != http.StatusUnauthorized {
+				t.Fatalf("attempt %d: status %d, want 401", i+1, w.Code)
+			}
+		}
+		if w := doJSON(r, http.MethodPost, "/login/mfa", "", gin.H{"mfa_token": token, "code": recovery}); w.Code != http.StatusUnauthorized {
+			t.Errorf("status %d after %d failures, want 401", w.Code, mfaMaxAttempts)
+		}
+	})
+}
+
+func TestCodeRequestsDoNotRevealPhones(t *testing.T) {
+	for _, path := range []string{"/otp/request", "/phone/verify/request", "/password/forgot"} {
+		t.Run(path, func(t *testing.T) {
+			r, h := newTestServer(t)
+			user := createTestUser(t, h, "+77011234567", RoleUser)
+			no := false
+			if _, err := h.users.Update(context.Background(), user.ID, UserUpdate{PhoneVerified: &no}); err != nil {
+				t.Fatal(err)
+			}
+
+			unknown := doJSON(r, http.MethodPost, path, "", gin.H{"phone": "+77019999999"})
+			if unknown.Code != http.StatusOK {
+				t.Fatalf("unknown phone: status %d, body %s", unknown.Code, unknown.Body.String())
+			}
+			// The second request comes before the resend delay is over
+			for i := 0; i < 2; i++ {
+				w := doJSON(r, http.MethodPost, path, "", gin.H{"phone": user.Phone})
+				if w.Code != unknown.Code || w.Body.String() != unknown.Body.String() {
+					t.Errorf("request %d: status %d, body %s: want the unknown phone response", i+1, w.Code, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCreatePost(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	token := loginTestUser(t, r, user.Phone)
+
+	if w := doJSON(r, http.MethodPost, "/protected/posts", "", gin.H{"title": "Hello"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous: status %d, want 401", w.Code)
+	}
+
+	w := doJSON(r, http.MethodPost, "/protected/posts", token, gin.H{"title": "Hello", "text": "World", "author_id": primitive.NewObjectID()})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	var post Post
+	decodeBody(t, w, &post)
+	if post.AuthorID != user.ID {
+		t.Errorf("author = %s, want the authenticated user %s", post.AuthorID.Hex(), user.ID.Hex())
+	}
+	if stored, ok := findTestPost(t, h, post.ID); !ok || stored.Title != "Hello" {
+		t.Errorf("stored post = %+v, found %v", stored, ok)
+	}
+}
+
+// findTestPost ищет пост по идентификатору среди всех постов хранилища.
+func findTestPost(t *testing.T, h *Handler, id primitive.ObjectID) (Post, bool) {
+	t.Helper()
+	posts, err := h.posts.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, post := range posts {
+		if post.ID == id {
+			return post, true
+		}
+	}
+	return Post{}, false
+}
+
+// createTestPost создает пост автора author напрямую в хранилище.
+func createTestPost(t *testing.T, h *Handler, author User) Post {
+	t.Helper()
+	post := Post{ID: primitive.NewObjectID(), Title: "Original", AuthorID: author.ID}
+	if err := h.posts.Create(context.Background(), post); err != nil {
+		t.Fatal(err)
+	}
+	return post
+}
+
+func TestUpdatePost(t *testing.T) {
+	r, h := newTestServer(t)
+	author := createTestUser(t, h, "+77011234567", RoleUser)
+	other := createTestUser(t, h, "+77011234568", RoleUser)
+	moderator := createTestUser(t, h, "+77011234569", RoleModerator)
+	tokens := map[string]string{
+		"author":    loginTestUser(t, r, author.Phone),
+		"other":     loginTestUser(t, r, other.Phone),
+		"moderator": loginTestUser(t, r, moderator.Phone),
+	}
+	post := createTestPost(t, h, author)
+
+	tests := []struct {
+		name  string
+		token string
+		path  string
+		want  int
+	}{
+		{"other user", "other", "/protected/posts/" + post.ID.Hex(), http.StatusNotFound},
+		{"invalid id", "author", "/protected/posts/nope", http.StatusBadRequest},
+		{"missing post", "author", "/protected/posts/" + primitive.NewObjectID().Hex(), http.StatusNotFound},
+		{"author", "author", "/protected/posts/" + post.ID.Hex(), http.StatusOK},
+		{"moderator", "moderator", "/protected/posts/" + post.ID.Hex(), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := doJSON(r, http.MethodPut, tt.path, tokens[tt.token], gin.H{"title": "Edited by " + tt.token})
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	stored, _ := findTestPost(t, h, post.ID)
+	if stored.Title != "Edited by moderator" || stored.AuthorID != author.ID {
+		t.Errorf("stored post = %+v: want moderator's title and the original author", stored)
+	}
+}
+
+func TestDeletePost(t *testing.T) {
+	r, h := newTestServer(t)
+	author := createTestUser(t, h, "+77011234567", RoleUser)
+	other := createTestUser(t, h, "+77011234568", RoleUser)
+	moderator := createTestUser(t, h, "+77011234569", RoleModerator)
+	authorToken := loginTestUser(t, r, author.Phone)
+	otherToken := loginTestUser(t, r, other.Phone)
+	moderatorToken := loginTestUser(t, r, moderator.Phone)
+
+	post := createTestPost(t, h, author)
+	if w := doJSON(r, http.MethodDelete, "/protected/posts/"+post.ID.Hex(), otherToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("other user: status %d, want 404", w.Code)
+	}
+	if w := doJSON(r, http.MethodDelete, "/protected/posts/nope", authorToken, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid id: status %d, want 400", w.Code)
+	}
+	if w := doJSON(r, http.MethodDelete, "/protected/posts/"+post.ID.Hex(), authorToken, nil); w.Code != http.StatusOK {
+		t.Errorf("author: status %d, want 200", w.Code)
+	}
+	if _, ok := findTestPost(t, h, post.ID); ok {
+		t.Error("post still exists after delete")
+	}
+	if w := doJSON(r, http.MethodDelete, "/protected/posts/"+post.ID.Hex(), authorToken, nil); w.Code != http.StatusNotFound {
+		t.Errorf("second delete: status %d, want 404", w.Code)
+	}
+
+	moderated := createTestPost(t, h, author)
+	if w := doJSON(r, http.MethodDelete, "/protected/posts/"+moderated.ID.Hex(), moderatorToken, nil); w.Code != http.StatusOK {
+		t.Errorf("moderator: status %d, want 200", w.Code)
+	}
+}
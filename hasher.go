@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordHasher хеширует и проверяет пароли одним алгоритмом.
+// Хеши хранятся в строковом формате PHC/modular crypt, по которому определяется алгоритм.
+type PasswordHasher interface {
+	// Hash возвращает закодированный хеш пароля с текущими параметрами.
+	Hash(password string) (string, error)
+	// Recognizes сообщает, создан ли хеш этим алгоритмом.
+	Recognizes(encoded string) bool
+	// Verify проверяет пароль по закодированному хешу.
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash сообщает, что хеш создан с параметрами, отличными от текущих.
+	NeedsRehash(encoded string) bool
+}
+
+// argon2idHasher реализует argon2id с хешами вида $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>.
+type argon2idHasher struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	saltLength  uint32
+	keyLength   uint32
+}
+
+type argon2idParams struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.iterations, h.memory, h.parallelism, h.keyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.iterations, h.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h argon2idHasher) Verify(password, encoded string) (bool, error) {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h argon2idHasher) NeedsRehash(encoded string) bool {
+	p, err := parseArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return p.memory != h.memory || p.iterations != h.iterations || p.parallelism != h.parallelism ||
+		uint32(len(p.salt)) != h.saltLength || uint32(len(p.key)) != h.keyLength
+}
+
+// parseArgon2id разбирает хеш argon2id в формате PHC.
+func parseArgon2id(encoded string) (argon2idParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return argon2idParams{}, errUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2idParams{}, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	var p argon2idParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return argon2idParams{}, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2idParams{}, err
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return argon2idParams{}, err
+	}
+	return p, nil
+}
+
+// bcryptHasher реализует bcrypt с хешами вида $2a$14$...
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(bytes), err
+}
+
+func (h bcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h bcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+var (
+	argon2idPasswordHasher = argon2idHasher{memory: 64 * 1024, iterations: 3, parallelism: 4, saltLength: 16, keyLength: 32}
+	bcryptPasswordHasher   = bcryptHasher{cost: 14}
+
+	// passwordHasher хеширует новые пароли; passwordHashers проверяют сохраненные хеши любых поддерживаемых алгоритмов.
+	passwordHasher  PasswordHasher = argon2idPasswordHasher
+	passwordHashers                = []PasswordHasher{argon2idPasswordHasher, bcryptPasswordHasher}
+)
+
+// initPasswordHasher выбирает алгоритм PASSWORD_HASH_ALGORITHM ("argon2id" или "bcrypt") и его параметры:
+// ARGON2_MEMORY_KIB, ARGON2_ITERATIONS, ARGON2_PARALLELISM и BCRYPT_COST.
+func initPasswordHasher() {
+	positive := func(name string, value, max int) uint32 {
+		if value < 1 || value > max {
+			log.Fatalf("invalid %s %d", name, value)
+		}
+		return uint32(value)
+	}
+
+	argon := argon2idPasswordHasher
+	argon.memory = positive("ARGON2_MEMORY_KIB", config.Argon2MemoryKiB, math.MaxInt32)
+	argon.iterations = positive("ARGON2_ITERATIONS", config.Argon2Iterations, math.MaxInt32)
+	argon.parallelism = uint8(positive("ARGON2_PARALLELISM", config.Argon2Parallelism, math.MaxUint8))
+
+	bc := bcryptPasswordHasher
+	bc.cost = config.BcryptCost
+	if bc.cost < bcrypt.MinCost || bc.cost > bcrypt.MaxCost {
+		log.Fatalf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	switch algorithm := config.PasswordHashAlgorithm; algorithm {
+	case "", "argon2id":
+		passwordHasher = argon
+	case "bcrypt":
+		passwordHasher = bc
+	default:
+		log.Fatalf("unknown PASSWORD_HASH_ALGORITHM %q", algorithm)
+	}
+	passwordHashers = []PasswordHasher{argon, bc}
+}
+
+// hasherFor находит алгоритм, которым создан хеш.
+func hasherFor(encoded string) (PasswordHasher, error) {
+	for _, h := range passwordHashers {
+		if h.Recognizes(encoded) {
+			return h, nil
+		}
+	}
+	return nil, errUnknownHashFormat
+}
+
+// passwordNeedsRehash сообщает, что хеш создан другим алгоритмом или с устаревшими параметрами.
+func passwordNeedsRehash(encoded string) bool {
+	h, err := hasherFor(encoded)
+	if err != nil {
+		return true
+	}
+	return h != passwordHasher || h.NeedsRehash(encoded)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// SigningMethodEdDSA реализует подпись JWT алгоритмом Ed25519 (alg "EdDSA"),
+// которого нет в jwt-go v3.
+type SigningMethodEdDSA struct{}
+
+var signingMethodEdDSA = &SigningMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(signingMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return signingMethodEdDSA
+	})
+}
+
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// signingKey описывает ключ подписи JWT. У ключей, выведенных из ротации, может не быть приватной части.
+type signingKey struct {
+	kid        string
+	method     jwt.SigningMethod
+	privateKey interface{}
+	publicKey  interface{}
+}
+
+// keySet хранит активный ключ подписи и все ключи, которые принимаются при проверке токенов.
+type keySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+var jwtKeys *keySet
+
+// initKeys загружает ключи подписи JWT из каталога JWT_KEYS_DIR.
+// Имя файла без расширения служит kid, активный ключ выбирается через JWT_ACTIVE_KID.
+// Без JWT_KEYS_DIR генерируется временный ключ Ed25519, пригодный только для разработки.
+func initKeys() {
+	dir := config.JWTKeysDir
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR is not set, using an ephemeral Ed25519 signing key")
+		ks, err := newEphemeralKeySet()
+		if err != nil {
+			log.Fatal(err)
+		}
+		jwtKeys = ks
+		return
+	}
+
+	ks, err := loadKeySet(dir, config.JWTActiveKID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jwtKeys = ks
+}
+
+// newEphemeralKeySet создает набор из одного случайного ключа Ed25519.
+func newEphemeralKeySet() (*keySet, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{kid: "ephemeral", method: signingMethodEdDSA, privateKey: privateKey, publicKey: publicKey}
+	return &keySet{active: key, keys: map[string]*signingKey{key.kid: key}}, nil
+}
+
+// loadKeySet читает PEM-файлы ключей из каталога и выбирает активный ключ по kid.
+func loadKeySet(dir, activeKID string) (*keySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &keySet{keys: make(map[string]*signingKey)}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := parseSigningKey(kid, file)
+		if err != nil {
+			return nil, fmt.Errorf("load signing key %s: %w", file, err)
+		}
+		ks.keys[kid] = key
+	}
+
+	active, ok := ks.keys[activeKID]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q not found in %s", activeKID, dir)
+	}
+	if active.privateKey == nil {
+		return nil, fmt.Errorf("active signing key %q has no private key", activeKID)
+	}
+	ks.active = active
+	return ks, nil
+}
+
+// parseSigningKey разбирает PEM-файл с приватным (PKCS#1 или PKCS#8) или публичным ключом RSA либо Ed25519.
+func parseSigningKey(kid, file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, privateKey: k, publicKey: &k.PublicKey}, nil
+	case *rsa.PublicKey:
+		return &signingKey{kid: kid, method: jwt.SigningMethodRS256, publicKey: k}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, method: signingMethodEdDSA, privateKey: k, publicKey: k.Public()}, nil
+	case ed25519.PublicKey:
+		return &signingKey{kid: kid, method: signingMethodEdDSA, publicKey: k}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+// sign подписывает claims активным ключом, указывая его kid в заголовке токена.
+func (ks *keySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.method, claims)
+	token.Header["kid"] = ks.active.kid
+	return token.SignedString(ks.active.privateKey)
+}
+
+// keyFunc подбирает ключ проверки по kid из заголовка токена. Алгоритм токена должен совпадать с алгоритмом ключа.
+func (ks *keySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+	return key.publicKey, nil
+}
+
+// jwk возвращает публичную часть ключа в формате JSON Web Key.
+func (k *signingKey) jwk() JWK {
+	jwk := JWK{Kid: k.kid, Alg: k.method.Alg(), Use: "sig"}
+	switch pub := k.publicKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// JWKS godoc
+//
+//	@Summary		JSON Web Key Set
+//	@Description	Public keys that other services can use to verify tokens issued by this server
+//	@Tags			Authentication
+//	@Produce		json
+//	@Success		200	{object}	JWKSet	"Key set"
+//	@Router			/.well-known/jwks.json [get]
+func JWKS(c *gin.Context) {
+	kids := make([]string, 0, len(jwtKeys.keys))
+	for kid := range jwtKeys.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKSet{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		set.Keys = append(set.Keys, jwtKeys.keys[kid].jwk())
+	}
+
+	c.JSON(http.StatusOK, set)
+}
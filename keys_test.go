@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+// writeTestKey сохраняет ключ в каталог dir в PEM-файл kid.pem.
+func writeTestKey(t *testing.T, dir, kid, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	dir := t.TempDir()
+
+	// Only the public part of the rotated-out key is left on the server
+	retired, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retiredPublic, err := x509.MarshalPKIXPublicKey(&retired.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestKey(t, dir, "2023-rsa", "PUBLIC KEY", retiredPublic)
+
+	_, active, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeDER, err := x509.MarshalPKCS8PrivateKey(active)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestKey(t, dir, "2024-ed25519", "PRIVATE KEY", activeDER)
+
+	if _, err := loadKeySet(dir, "2023-rsa"); err == nil {
+		t.Error("a key without the private part was accepted as the active key")
+	}
+	if _, err := loadKeySet(dir, "missing"); err == nil {
+		t.Error("an unknown active kid was accepted")
+	}
+	ks, err := loadKeySet(dir, "2024-ed25519")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.StandardClaims{Subject: "user", ExpiresAt: time.Now().Add(time.Minute).Unix()}
+	t.Run("active key signs", func(t *testing.T) {
+		signed, err := ks.sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := jwt.Parse(signed, ks.keyFunc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Header["kid"] != "2024-ed25519" || token.Method.Alg() != "EdDSA" {
+			t.Errorf("header %v, want the active Ed25519 key", token.Header)
+		}
+	})
+
+	t.Run("rotated-out key verifies", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "2023-rsa"
+		signed, err := token.SignedString(retired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jwt.Parse(signed, ks.keyFunc); err != nil {
+			t.Errorf("token of the rotated-out key rejected: %v", err)
+		}
+	})
+
+	t.Run("unknown kid and algorithm mismatch", func(t *testing.T) {
+		unknown := jwt.NewWithClaims(signingMethodEdDSA, claims)
+		unknown.Header["kid"] = "2022-lost"
+		signed, _ := unknown.SignedString(active)
+		if _, err := jwt.Parse(signed, ks.keyFunc); err == nil {
+			t.Error("token with an unknown kid accepted")
+		}
+
+		// The public RSA key must not be usable as an HMAC secret
+		confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		confused.Header["kid"] = "2023-rsa"
+		signed, _ = confused.SignedString(retiredPublic)
+		if _, err := jwt.Parse(signed, ks.keyFunc); err == nil {
+			t.Error("HS256 token accepted for an RSA key")
+		}
+	})
+
+	t.Run("JWKS", func(t *testing.T) {
+		previous := jwtKeys
+		jwtKeys = ks
+		t.Cleanup(func() { jwtKeys = previous })
+
+		r := gin.New()
+		r.GET("/.well-known/jwks.json", JWKS)
+		w := doJSON(r, http.MethodGet, "/.well-known/jwks.json", "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d", w.Code)
+		}
+		if strings.Contains(w.Body.String(), `"d"`) {
+			t.Errorf("key set %s contains private key material", w.Body.String())
+		}
+		var set JWKSet
+		decodeBody(t, w, &set)
+		if len(set.Keys) != 2 {
+			t.Fatalf("keys = %+v, want both keys", set.Keys)
+		}
+
+		rsaKey, edKey := set.Keys[0], set.Keys[1]
+		wantN := base64.RawURLEncoding.EncodeToString(retired.N.Bytes())
+		wantE := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(retired.E)).Bytes())
+		if rsaKey.Kid != "2023-rsa" || rsaKey.Kty != "RSA" || rsaKey.Alg != "RS256" || rsaKey.Use != "sig" || rsaKey.N != wantN || rsaKey.E != wantE {
+			t.Errorf("RSA key = %+v", rsaKey)
+		}
+		wantX := base64.RawURLEncoding.EncodeToString(active.Public().(ed25519.PublicKey))
+		if edKey.Kid != "2024-ed25519" || edKey.Kty != "OKP" || edKey.Crv != "Ed25519" || edKey.Alg != "EdDSA" || edKey.X != wantX {
+			t.Errorf("Ed25519 key = %+v", edKey)
+		}
+	})
+}
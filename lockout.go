@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	loginAttemptWindow      = time.Hour
+	loginBackoffThreshold   = 3
+	loginBackoffBase        = time.Second
+	loginBackoffMax         = 5 * time.Minute
+	accountLockoutThreshold = 10
+	ipLockoutThreshold      = 50
+	loginLockoutDuration    = 15 * time.Minute
+)
+
+// dummyPasswordHash используется для сравнения, когда телефон не найден, чтобы время ответа
+// не выдавало, зарегистрирован ли номер. Хеш создается текущим алгоритмом, как и хеши пользователей.
+var dummyPasswordHash string
+
+// initLoginProtection вычисляет фиктивный хеш пароля. Вызывается после initPasswordHasher.
+func initLoginProtection() {
+	hash, err := HashPassword("dummy password for timing equalization")
+	if err != nil {
+		log.Fatal(err)
+	}
+	dummyPasswordHash = hash
+}
+
+// phoneAttemptKey и ipAttemptKey задают ключи счетчиков неудачных входов.
+func phoneAttemptKey(phone string) string { return "phone:" + phone }
+func ipAttemptKey(ip string) string       { return "ip:" + ip }
+
+// loginBackoff возвращает задержку перед следующей попыткой: она удваивается с каждой неудачей после порога.
+func loginBackoff(failures int) time.Duration {
+	if failures < loginBackoffThreshold {
+		return 0
+	}
+	wait := float64(loginBackoffBase) * math.Pow(2, float64(failures-loginBackoffThreshold))
+	if wait > float64(loginBackoffMax) {
+		return loginBackoffMax
+	}
+	return time.Duration(wait)
+}
+
+// loginBlockedFor возвращает, сколько еще нужно подождать до следующей попытки входа по любому из ключей.
+func (h *Handler) loginBlockedFor(ctx context.Context, keys ...string) (time.Duration, error) {
+	attempts, err := h.loginAttempts.Find(ctx, keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	for _, a := range attempts {
+		if now.Sub(a.LastFailure) > loginAttemptWindow {
+			continue
+		}
+		if d := a.LockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+		if d := a.LastFailure.Add(loginBackoff(a.Failures)).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// recordLoginFailure увеличивает счетчик неудачных попыток по ключу и блокирует ключ на loginLockoutDuration
+// каждый раз, когда число неудач достигает кратного threshold. Возвращает время окончания новой блокировки.
+func (h *Handler) recordLoginFailure(ctx context.Context, key string, threshold int) (time.Time, error) {
+	now := time.Now()
+	attempt, err := h.loginAttempts.RecordFailure(ctx, key,
+		now.Add(-loginAttemptWindow), now, now.Add(loginAttemptWindow+loginLockoutDuration))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if attempt.Failures%threshold != 0 {
+		return time.Time{}, nil
+	}
+
+	lockedUntil := now.Add(loginLockoutDuration)
+	return lockedUntil, h.loginAttempts.Lock(ctx, key, lockedUntil)
+}
+
+// resetLoginFailures сбрасывает счетчик после успешного входа.
+func (h *Handler) resetLoginFailures(ctx context.Context, key string) error {
+	return h.loginAttempts.Reset(ctx, key)
+}
+
+// notifyAccountLocked сообщает владельцу аккаунта о временной блокировке и времени разблокировки.
+func notifyAccountLocked(ctx context.Context, user User, lockedUntil time.Time) {
+	message := fmt.Sprintf("Too many failed login attempts. Your account is locked until %s. If it wasn't you, reset your password.",
+		lockedUntil.UTC().Format("15:04 MST"))
+	if err := notifier.Notify(ctx, user, "Account temporarily locked", message); err != nil {
+		log.Println("Error sending lockout notification:", err)
+	}
+}
@@ -7,14 +7,10 @@ import (
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"log"
 	"net/http"
-	"sync"
-)
-
-var (
-	// Simulating a token blacklist
-	tokenBlacklist = make(map[string]struct{})
-	mutex          = &sync.Mutex{}
+	"os"
+	"time"
 )
 
 //	@title			Authorization
@@ -28,35 +24,132 @@ var (
 // @in							header
 // @name						Authorization
 func main() {
-	initDB()
+	var args []string
+	var err error
+	config, args, err = loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(args) > 0 && args[0] == "config" {
+		runConfigCommand(args[1:])
+		return
+	}
+	if err := config.validate(); err != nil {
+		log.Fatal(err)
+	}
+	// Migrations normalize legacy phones, so the default region is needed before them
+	initPhone()
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			runMigrateCommand(args[1:])
+			return
+		case "user":
+			runUserCommand(args[1:])
+			return
+		default:
+			log.Fatalf("unknown command %q", args[0])
+		}
+	}
+
+	initKeys()
+	initSMS()
+	initPasswordPolicy()
+	initPasswordHasher()
+	initLoginProtection()
+	initWebAuthn()
+	initAuthCookies()
+	h := NewHandler(initStores())
+	r := newRouter(h)
+
+	r.Run(config.HTTPAddr)
+}
+
+// newRouter регистрирует маршруты API поверх обработчиков h.
+func newRouter(h *Handler) *gin.Engine {
 	r := gin.Default()
 	//url := ginSwagger.URL("http://localhost:8080/docs/swagger.json")
 	docs.SwaggerInfo.BasePath = "/api/v1"
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
 	// Initialize CORS middleware
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://10.144.38.171:3000"}                        // Specify origins you want to allow
-	config.AllowMethods = []string{http.MethodGet, http.MethodPost, http.MethodDelete} // Specify methods you want to allow
-	config.AllowCredentials = true                                                     // Allow sending cookies from the origin
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOrigins = config.CORSOrigins                                                                             // Specify origins you want to allow
+	corsConfig.AllowMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} // Specify methods you want to allow
+	corsConfig.AllowCredentials = true                                                                                       // Allow sending cookies from the origin
+	corsConfig.AddAllowHeaders(authModeHeader, csrfTokenHeader)
 
 	// Use CORS middleware
-	r.Use(cors.New(config))
+	r.Use(cors.New(corsConfig))
 
-	r.POST("/register", Register)
-	r.POST("/login", Login)
-	r.POST("/update/:id", UpdatePassword)
-	r.POST("/logout", Logout)
-	r.GET("/users/:id", UserProfile)
+	r.GET("/.well-known/jwks.json", JWKS)
+	r.GET("/.well-known/openid-configuration", OpenIDConfiguration)
+
+	r.GET("/oauth/authorize", h.AuthMiddleware(), h.Authorize)
+	r.POST("/oauth/authorize", h.AuthMiddleware(), h.Authorize)
+	r.POST("/oauth/token", h.Token)
+	r.POST("/oauth/introspect", h.Introspect)
+	r.POST("/oauth/revoke", h.Revoke)
+	r.GET("/userinfo", h.UserInfo)
+
+	// Endpoints that send SMS share one limit, code checks share another
+	smsLimit := h.RateLimitMiddleware("sms", RateLimit{Requests: 5, Per: 10 * time.Minute}, KeyByIP)
+	codeLimit := h.RateLimitMiddleware("code", RateLimit{Requests: 20, Per: 10 * time.Minute}, KeyByIP)
+
+	r.POST("/register", h.RateLimitMiddleware("register", RateLimit{Requests: 5, Per: time.Hour}, KeyByIP), h.Register)
+	r.POST("/login", h.RateLimitMiddleware("login", RateLimit{Requests: 20, Per: time.Minute}, KeyByIP), h.Login)
+	r.POST("/login/mfa", codeLimit, h.LoginMFA)
+	r.POST("/webauthn/login/begin", codeLimit, h.BeginWebAuthnLogin)
+	r.POST("/webauthn/login/finish", codeLimit, h.FinishWebAuthnLogin)
+	r.POST("/token/refresh", h.Refresh)
+	r.POST("/otp/request", smsLimit, h.RequestOTP)
+	r.POST("/otp/verify", codeLimit, h.VerifyOTP)
+	r.POST("/phone/verify", codeLimit, h.VerifyPhone)
+	r.POST("/phone/verify/request", smsLimit, h.RequestPhoneVerification)
+	r.POST("/password/forgot", smsLimit, h.ForgotPassword)
+	r.POST("/password/reset", codeLimit, h.ResetPassword)
+	r.POST("/logout", h.AuthMiddleware(), h.Logout)
+	r.GET("/users/:id", h.UserProfile)
 
 	protected := r.Group("/protected")
-	protected.Use(AuthMiddleware())
-	protected.GET("/", Protected)
+	protected.Use(h.AuthMiddleware())
+	protected.POST("/password", h.UpdatePassword)
+	protected.PATCH("/profile", h.UpdateProfile)
+	protected.POST("/mfa/totp/enroll", h.EnrollTOTP)
+	protected.GET("/mfa/totp/qr.png", TOTPQRCode)
+	protected.POST("/mfa/totp/confirm", h.ConfirmTOTP)
+	protected.POST("/mfa/totp/disable", h.DisableTOTP)
+	protected.POST("/webauthn/register/begin", h.BeginWebAuthnRegistration)
+	protected.POST("/webauthn/register/finish", h.FinishWebAuthnRegistration)
+	protected.GET("/webauthn/credentials", h.ListWebAuthnCredentials)
+	protected.DELETE("/webauthn/credentials/:id", h.DeleteWebAuthnCredential)
+
+	protected.GET("/api-keys", h.ListAPIKeys)
+	protected.POST("/api-keys", h.CreateAPIKey)
+	protected.PATCH("/api-keys/:id", h.RenameAPIKey)
+	protected.DELETE("/api-keys/:id", h.RevokeAPIKey)
+
+	protected.GET("/sessions", h.ListSessions)
+	protected.DELETE("/sessions/:id", h.DeleteSession)
+	protected.POST("/sessions/revoke-all", h.RevokeAllSessions)
+
+	// Routes that also accept personal API keys granting the listed scope, each key is limited separately
+	scoped := r.Group("/protected")
+	apiLimit := h.RateLimitMiddleware("api", RateLimit{Requests: 120, Per: time.Minute}, KeyByAPIKey)
+	scoped.GET("/", h.AuthMiddleware(ScopeProfileRead), apiLimit, Protected)
+	scoped.GET("/posts", h.AuthMiddleware(ScopePostsRead), apiLimit, h.GetPosts)
+	scoped.POST("/posts", h.AuthMiddleware(ScopePostsWrite), apiLimit, h.RateLimitMiddleware("create-post", RateLimit{Requests: 30, Per: time.Minute}, KeyByPhone), h.CreatePost)
+	scoped.PUT("/posts/:id", h.AuthMiddleware(ScopePostsWrite), apiLimit, h.UpdatePost)
+	scoped.DELETE("/posts/:id", h.AuthMiddleware(ScopePostsWrite), apiLimit, h.DeletePost)
 
-	protected.GET("/posts", GetPosts)
-	protected.POST("/posts", CreatePost)
-	protected.PUT("/posts/:id", UpdatePost)
-	protected.DELETE("/posts/:id", DeletePost)
+	admin := r.Group("/admin")
+	admin.Use(h.AuthMiddleware(), RequirePermission(PermissionUsersManage))
+	admin.GET("/users", h.AdminListUsers)
+	admin.PUT("/users/:id/disabled", h.AdminSetUserDisabled)
+	admin.POST("/users/:id/password-reset", h.AdminResetPassword)
+	admin.PUT("/users/:id/role", h.AdminSetUserRole)
+	admin.DELETE("/users/:id", h.AdminDeleteUser)
+	admin.POST("/oauth/clients", h.CreateOAuthClient)
 
-	r.Run(":8080")
+	return r
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testPassword = "Password123"
+
+// testSMSSender запоминает отправленные сообщения вместо отправки.
+type testSMSSender struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func (s *testSMSSender) Send(_ context.Context, phone, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.messages == nil {
+		s.messages = map[string][]string{}
+	}
+	s.messages[phone] = append(s.messages[phone], message)
+	return nil
+}
+
+// last возвращает последнее сообщение, отправленное на phone.
+func (s *testSMSSender) last(phone string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := s.messages[phone]
+	if len(messages) == 0 {
+		return ""
+	}
+	return messages[len(messages)-1]
+}
+
+var testSMS = &testSMSSender{}
+
+// TestMain настраивает сервис так же, как main, но без внешних зависимостей:
+// хранилища в памяти, временный ключ подписи и дешевый bcrypt.
+func TestMain(m *testing.M) {
+	var err error
+	config, _, err = loadConfig([]string{
+		"-config", os.DevNull,
+		"-storage-backend", "memory",
+		"-password-hash-algorithm", "bcrypt",
+		"-bcrypt-cost", "4",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := config.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	log.SetOutput(io.Discard)
+
+	initKeys()
+	initSMS()
+	smsSender = testSMS
+	notifier = smsNotifier{sender: testSMS}
+	initPhone()
+	initPasswordPolicy()
+	initPasswordHasher()
+	initLoginProtection()
+	initWebAuthn()
+	initAuthCookies()
+
+	code := m.Run()
+	stopTestPostgres()
+	os.Exit(code)
+}
+
+// newTestServer возвращает маршрутизатор поверх новых хранилищ в памяти.
+func newTestServer(t *testing.T) (*gin.Engine, *Handler) {
+	t.Helper()
+	h := NewHandler(newMemoryStores())
+	return newRouter(h), h
+}
+
+// doJSON выполняет запрос с телом body в JSON и токеном доступа, если он задан.
+func doJSON(r http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// decodeBody разбирает JSON-ответ.
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+}
+
+// createTestUser сохраняет пользователя с паролем testPassword напрямую в хранилище.
+func createTestUser(t *testing.T, h *Handler, phone, role string) User {
+	t.Helper()
+	hash, err := HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{
+		ID:            primitive.NewObjectID(),
+		Name:          "Test " + strings.TrimPrefix(phone, "+"),
+		Phone:         phone,
+		PhoneVerified: true,
+		Password:      hash,
+		Role:          role,
+	}
+	if err := h.users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// loginTestUser входит паролем testPassword и возвращает access-токен.
+func loginTestUser(t *testing.T, r http.Handler, phone string) string {
+	t.Helper()
+	w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": phone, "password": testPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("login %s: status %d, body %s", phone, w.Code, w.Body.String())
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	decodeBody(t, w, &body)
+	if body.Token == "" {
+		t.Fatalf("login %s: no token in %s", phone, w.Body.String())
+	}
+	return body.Token
+}
+
+// parseTestToken разбирает access-токен, выданный сервисом.
+func parseTestToken(t *testing.T, token string) *Claims {
+	t.Helper()
+	claims := &Claims{}
+	if _, err := jwt.ParseWithClaims(token, claims, jwtKeys.keyFunc); err != nil {
+		t.Fatalf("parse token: %v", err)
+	}
+	return claims
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"image/png"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	mfaTokenTTL        = 5 * time.Minute
+	mfaTokenAudience   = "mfa"
+	mfaMaxAttempts     = 5
+	totpPeriod         = 30
+	totpSkew           = 1
+	recoveryCodeCount  = 10
+	totpQRCodeSize     = 256
+	recoveryCodeLength = 10
+)
+
+var errMFACodeInvalid = errors.New("invalid MFA code")
+
+// MFAClaims описывает короткоживущий токен, выданный после проверки пароля, но до проверки второго фактора.
+type MFAClaims struct {
+	Phone string `json:"phone"`
+	jwt.StandardClaims
+}
+
+// mfaAttemptKey задает ключ счетчика неверных кодов для одного mfa_token.
+func mfaAttemptKey(jti string) string { return "mfa:" + jti }
+
+// totpIssuer возвращает имя сервиса, которое приложение-аутентификатор покажет рядом с кодом.
+func totpIssuer() string {
+	return config.TOTPIssuer
+}
+
+// generateRecoveryCodes создает одноразовые коды восстановления и их хеши для хранения.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, recoveryCodeLength*5/8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		code := raw[:recoveryCodeLength/2] + "-" + raw[recoveryCodeLength/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode хеширует код восстановления без учета регистра и дефиса.
+func hashRecoveryCode(code string) string {
+	return hashToken(strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", "")))
+}
+
+// validateTOTPStep проверяет TOTP-код с допуском в один период и возвращает номер совпавшего периода.
+func validateTOTPStep(secret, code string, now time.Time) (int64, bool) {
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	current := now.Unix() / totpPeriod
+	for skew := int64(-totpSkew); skew <= totpSkew; skew++ {
+		step := current + skew
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), opts)
+		if err == nil && expected == code {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// verifySecondFactor принимает TOTP-код или одноразовый код восстановления.
+// Каждый TOTP-код принимается только один раз, использованный код восстановления удаляется.
+func (h *Handler) verifySecondFactor(ctx context.Context, user User, code string) error {
+	code = strings.TrimSpace(code)
+
+	var (
+		used bool
+		err  error
+	)
+	if step, ok := validateTOTPStep(user.TOTPSecret, code, time.Now()); ok {
+		used, err = h.users.UseTOTPStep(ctx, user.ID, step)
+	} else {
+		used, err = h.users.UseRecoveryCode(ctx, user.ID, hashRecoveryCode(code))
+	}
+	if err != nil {
+		return err
+	}
+	if !used {
+		return errMFACodeInvalid
+	}
+	return nil
+}
+
+// completeLogin завершает вход после проверки первого фактора: выдает токены
+// или, если включена двухфакторная аутентификация, токен ожидания второго фактора.
+func (h *Handler) completeLogin(c *gin.Context, user User) {
+	if !user.TOTPEnabled {
+		h.respondWithTokens(c, user)
+		return
+	}
+
+	jti, err := generateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	now := time.Now()
+	mfaToken, err := jwtKeys.sign(&MFAClaims{
+		Phone: user.Phone,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Audience:  mfaTokenAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(mfaTokenTTL).Unix(),
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": mfaToken})
+}
+
+// LoginMFA godoc
+//
+//	@Summary		Complete login with a second factor
+//	@Description	Exchange the mfa_token returned by Login and a TOTP or recovery code for the normal access and refresh tokens. The mfa_token is single-use and is invalidated after 5 wrong codes.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			request			body		MFALoginRequest			true	"MFA token and code"
+//	@Param			X-Device-Name	header		string					false	"Device name shown in the session list"
+//	@Success		200				{object}	map[string]interface{}	"Access and refresh tokens"
+//	@Failure		400				{object}	map[string]string		"Invalid input"
+//	@Failure		401				{object}	map[string]string		"Invalid MFA token or code"
+//	@Failure		500				{object}	map[string]string		"Failed to verify code"
+//	@Router			/login/mfa [post]
+func (h *Handler) LoginMFA(c *gin.Context) {
+	var req MFALoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims := &MFAClaims{}
+	token, err := jwt.ParseWithClaims(req.MFAToken, claims, jwtKeys.keyFunc)
+	if err != nil || !token.Valid || !claims.VerifyAudience(mfaTokenAudience, true) || claims.Id == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+	ctx := context.Background()
+
+	// The token is revoked once it is exchanged or after too many wrong codes
+	revoked, err := h.revokedTokens.IsRevoked(ctx, claims.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token revocation"})
+		return
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	user, err := h.users.FindByPhone(ctx, claims.Phone)
+	if err != nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if err := h.verifySecondFactor(ctx, user, req.Code); err != nil {
+		if !errors.Is(err, errMFACodeInvalid) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		now := time.Now()
+		attempt, err := h.loginAttempts.RecordFailure(ctx, mfaAttemptKey(claims.Id), now.Add(-mfaTokenTTL), now, expiresAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+			return
+		}
+		if attempt.Failures >= mfaMaxAttempts {
+			if err := h.revokedTokens.Revoke(ctx, claims.Id, expiresAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many invalid codes, log in again"})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if err := h.revokedTokens.Revoke(ctx, claims.Id, expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if err := h.loginAttempts.Reset(ctx, mfaAttemptKey(claims.Id)); err != nil {
+		log.Println("Error resetting MFA attempts:", err)
+	}
+
+	h.respondWithTokens(c, user)
+}
+
+// EnrollTOTP godoc
+//
+//	@Summary		Start TOTP enrollment
+//	@Description	Generate a new TOTP secret for the authenticated user. It takes effect only after ConfirmTOTP.
+//	@Tags			MFA
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{object}	map[string]string	"Secret, otpauth URI and QR code PNG (base64)"
+//	@Failure		409	{object}	map[string]string	"TOTP is already enabled"
+//	@Failure		500	{object}	map[string]string	"Failed to start enrollment"
+//	@Router			/protected/mfa/totp/enroll [post]
+func (h *Handler) EnrollTOTP(c *gin.Context) {
+	user := currentUser(c)
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "TOTP is already enabled"})
+		return
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer(), AccountName: user.Phone, Period: totpPeriod})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+	pendingURL := key.URL()
+	_, err = h.users.Update(context.Background(), user.ID, UserUpdate{TOTPPendingURL: &pendingURL})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	qr, err := totpQRCode(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      key.Secret(),
+		"otpauth_url": key.URL(),
+		"qr_code_png": base64.StdEncoding.EncodeToString(qr),
+	})
+}
+
+// totpQRCode рисует otpauth:// URI ключа в виде QR-кода PNG.
+func totpQRCode(key *otp.Key) ([]byte, error) {
+	img, err := key.Image(totpQRCodeSize, totpQRCodeSize)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// TOTPQRCode godoc
+//
+//	@Summary		TOTP enrollment QR code
+//	@Description	QR code PNG of the pending TOTP enrollment for scanning with an authenticator app
+//	@Tags			MFA
+//	@Produce		png
+//	@Security		ApiKeyAuth
+//	@Success		200	{file}		binary				"QR code"
+//	@Failure		404	{object}	map[string]string	"No pending enrollment"
+//	@Router			/protected/mfa/totp/qr.png [get]
+func TOTPQRCode(c *gin.Context) {
+	user := currentUser(c)
+	if user.TOTPPendingURL == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending TOTP enrollment"})
+		return
+	}
+
+	key, err := otp.NewKeyFromURL(user.TOTPPendingURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+		return
+	}
+	qr, err := totpQRCode(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", qr)
+}
+
+// ConfirmTOTP godoc
+//
+//	@Summary		Confirm TOTP enrollment
+//	@Description	Enable TOTP with a code from the authenticator app. Returns one-time recovery codes, shown only once.
+//	@Tags			MFA
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			request	body		MFACodeRequest			true	"Code from the authenticator app"
+//	@Success		200		{object}	map[string]interface{}	"Recovery codes"
+//	@Failure		400		{object}	map[string]string		"Invalid input or no pending enrollment"
+//	@Failure		401		{object}	map[string]string		"Invalid code"
+//	@Failure		500		{object}	map[string]string		"Failed to enable TOTP"
+//	@Router			/protected/mfa/totp/confirm [post]
+func (h *Handler) ConfirmTOTP(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := currentUser(c)
+	if user.TOTPPendingURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No pending TOTP enrollment"})
+		return
+	}
+	key, err := otp.NewKeyFromURL(user.TOTPPendingURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable TOTP"})
+		return
+	}
+	step, ok := validateTOTPStep(key.Secret(), strings.TrimSpace(req.Code), time.Now())
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable TOTP"})
+		return
+	}
+	enabled, secret, noPendingURL := true, key.Secret(), ""
+	_, err = h.users.Update(context.Background(), user.ID, UserUpdate{
+		TOTPEnabled:    &enabled,
+		TOTPSecret:     &secret,
+		TOTPLastStep:   &step,
+		RecoveryCodes:  &hashes,
+		TOTPPendingURL: &noPendingURL,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable TOTP"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "TOTP enabled successfully", "recovery_codes": codes})
+}
+
+// DisableTOTP godoc
+//
+//	@Summary		Disable TOTP
+//	@Description	Turn off two-factor authentication after checking a TOTP or recovery code
+//	@Tags			MFA
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			request	body		MFACodeRequest		true	"TOTP or recovery code"
+//	@Success		200		{object}	map[string]string	"TOTP disabled successfully"
+//	@Failure		400		{object}	map[string]string	"Invalid input or TOTP is not enabled"
+//	@Failure		401		{object}	map[string]string	"Invalid code"
+//	@Failure		500		{object}	map[string]string	"Failed to disable TOTP"
+//	@Router			/protected/mfa/totp/disable [post]
+func (h *Handler) DisableTOTP(c *gin.Context) {
+	var req MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user := currentUser(c)
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "TOTP is not enabled"})
+		return
+	}
+	if err := h.verifySecondFactor(context.Background(), user, req.Code); err != nil {
+		if errors.Is(err, errMFACodeInvalid) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable TOTP"})
+		}
+		return
+	}
+
+	// Empty values remove the secret, the last used step and the recovery codes
+	enabled, noSecret, noStep, noCodes := false, "", int64(0), []string{}
+	_, err := h.users.Update(context.Background(), user.ID, UserUpdate{
+		TOTPEnabled:   &enabled,
+		TOTPSecret:    &noSecret,
+		TOTPLastStep:  &noStep,
+		RecoveryCodes: &noCodes,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable TOTP"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "TOTP disabled successfully"})
+}
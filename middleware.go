@@ -1,57 +1,192 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// AuthMiddleware функция middleware для аутентификации через JWT.
+const currentUserKey = "currentUser"
+
+// passwordChangeRoutes - маршруты, доступные пользователю, которому нужно сменить временный пароль.
+var passwordChangeRoutes = map[string]bool{
+	"/protected/password": true,
+	"/logout":             true,
+}
+
+// rejectUntilPasswordChanged отвечает 403 и возвращает true, если пользователь должен сменить пароль,
+// а маршрут не входит в passwordChangeRoutes.
+func rejectUntilPasswordChanged(c *gin.Context, user User) bool {
+	if !user.MustChangePassword || passwordChangeRoutes[c.FullPath()] {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Password change required"})
+	c.Abort()
+	return true
+}
+
+// AuthMiddleware функция middleware для аутентификации через JWT (из заголовка или cookie jwt_token) или API-ключ.
+// API-ключи принимаются, только если маршрут перечисляет нужные scope и ключ выдает их все.
 //
-//	@Summary		Middleware для проверки авторизации через JWT
-//	@Description	Middleware проверяет наличие и валидность JWT в заголовке Authorization.
+//	@Summary		Middleware для проверки авторизации через JWT или API-ключ
+//	@Description	Middleware проверяет наличие и валидность JWT или API-ключа в заголовке Authorization.
 //	@Tags			Authentication
 //	@Accept			json
 //	@Produce		json
 //	@Security		BearerToken
-//	@Param			Authorization	header		string				true	"Bearer JWT token"
+//	@Param			Authorization	header		string				true	"Bearer JWT token or ApiKey key"
 //	@Success		200				{string}	string				"Authorized"
 //	@Failure		401				{object}	map[string]string	"Unauthorized"
+//	@Failure		403				{object}	map[string]string	"API key is not allowed or lacks a scope, or the password must be changed first"
 //	@Router			/auth [get]
-func AuthMiddleware() gin.HandlerFunc {
+func (h *Handler) AuthMiddleware(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+			// Browser clients in cookie mode send the access token as a cookie, so the request needs a CSRF token
+			cookie, err := c.Cookie(accessTokenCookie)
+			if err != nil || cookie == "" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+				c.Abort()
+				return
+			}
+			tokenString = cookie
+			c.Set("auth_cookie", true)
+			if !checkCSRF(c) {
+				return
+			}
+		}
+
+		if key, ok := strings.CutPrefix(authHeader, "ApiKey "); ok {
+			h.authenticateWithAPIKey(c, key, scopes)
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-
-		// Check if token is blacklisted
-		mutex.Lock()
-		_, blacklisted := tokenBlacklist[tokenString]
-		mutex.Unlock()
-		if blacklisted {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is blacklisted (logged out)"})
+		token, err := jwt.ParseWithClaims(tokenString, claims, jwtKeys.keyFunc)
+
+		// MFA and OAuth tokens are signed with the same keys but must not open the API
+		if err != nil || !token.Valid || !claims.VerifyAudience(accessTokenAudience, true) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		// Check if token was revoked (logged out)
+		revoked, err := h.revokedTokens.IsRevoked(context.Background(), claims.Id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token revocation"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked (logged out)"})
+			c.Abort()
+			return
+		}
+
+		// Check if the session is still active
+		user, err := h.validateSession(context.Background(), claims, c.ClientIP())
+		if err != nil {
+			if errors.Is(err, errSessionRevoked) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			} else if errors.Is(err, errAccountDisabled) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+			}
 			c.Abort()
 			return
 		}
+		if rejectUntilPasswordChanged(c, user) {
+			return
+		}
 
-		c.Set("phone", claims.Phone)
+		setCurrentUser(c, user)
+		c.Set("claims", claims)
 		c.Next()
 	}
 }
+
+// setCurrentUser сохраняет аутентифицированного пользователя в контексте запроса.
+// Телефон и роль дублируются отдельными ключами для middleware, которым не нужен весь пользователь.
+func setCurrentUser(c *gin.Context, user User) {
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+	c.Set(currentUserKey, user)
+	c.Set("phone", user.Phone)
+	c.Set("role", user.Role)
+}
+
+// currentUser возвращает пользователя, аутентифицированного AuthMiddleware.
+// Вне маршрутов с AuthMiddleware возвращает пустого пользователя.
+func currentUser(c *gin.Context) User {
+	value, _ := c.Get(currentUserKey)
+	user, _ := value.(User)
+	return user
+}
+
+// currentUserID возвращает идентификатор пользователя, аутентифицированного AuthMiddleware.
+func currentUserID(c *gin.Context) primitive.ObjectID {
+	return currentUser(c).ID
+}
+
+// RequirePermission пропускает запрос, только если роль пользователя выдает все указанные разрешения.
+// Используется после AuthMiddleware.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, p := range permissions {
+			if !hasPermission(role, p) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// authenticateWithAPIKey проверяет API-ключ и его scope для AuthMiddleware.
+func (h *Handler) authenticateWithAPIKey(c *gin.Context, key string, scopes []string) {
+	if len(scopes) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API keys are not accepted for this endpoint"})
+		c.Abort()
+		return
+	}
+
+	apiKey, user, err := h.authenticateAPIKey(context.Background(), key)
+	if err != nil {
+		if errors.Is(err, errAPIKeyInvalid) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		} else if errors.Is(err, errAccountDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check API key"})
+		}
+		c.Abort()
+		return
+	}
+	for _, scope := range scopes {
+		if !containsString(apiKey.Scopes, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks scope " + scope})
+			c.Abort()
+			return
+		}
+	}
+	if rejectUntilPasswordChanged(c, user) {
+		return
+	}
+
+	setCurrentUser(c, user)
+	c.Set("api_key", apiKey)
+	c.Next()
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newCurrentUserRouter возвращает маршрут за AuthMiddleware, который отдает currentUser и claims запроса.
+func newCurrentUserRouter(h *Handler) *gin.Engine {
+	r := gin.New()
+	r.POST("/login", h.Login)
+	r.GET("/me", h.AuthMiddleware(), func(c *gin.Context) {
+		claims := c.MustGet("claims").(*Claims)
+		c.JSON(http.StatusOK, gin.H{"user": userSummary(currentUser(c)), "user_id": currentUserID(c), "session_id": claims.SessionID})
+	})
+	return r
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(t *testing.T, h *Handler, user User, token string)
+		want   int
+	}{
+		{
+			name:   "valid token",
+			revoke: func(*testing.T, *Handler, User, string) {},
+			want:   http.StatusOK,
+		},
+		{
+			name: "revoked token",
+			revoke: func(t *testing.T, h *Handler, _ User, token string) {
+				claims := parseTestToken(t, token)
+				if err := h.revokedTokens.Revoke(context.Background(), claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "disabled account",
+			revoke: func(t *testing.T, h *Handler, user User, _ string) {
+				yes := true
+				if _, err := h.users.Update(context.Background(), user.ID, UserUpdate{Disabled: &yes}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: http.StatusForbidden,
+		},
+		{
+			name: "mismatched token version",
+			revoke: func(t *testing.T, h *Handler, user User, _ string) {
+				if _, err := h.users.Update(context.Background(), user.ID, UserUpdate{IncTokenVersion: true}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "terminated session",
+			revoke: func(t *testing.T, h *Handler, user User, token string) {
+				if err := h.sessions.Revoke(context.Background(), sessionObjectID(parseTestToken(t, token).SessionID), user.Phone); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(newMemoryStores())
+			r := newCurrentUserRouter(h)
+			user := createTestUser(t, h, "+77011234567", RoleModerator)
+			token := loginTestUser(t, r, user.Phone)
+
+			tt.revoke(t, h, user, token)
+
+			w := doJSON(r, http.MethodGet, "/me", token, nil)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var body struct {
+				User      UserSummary `json:"user"`
+				UserID    string      `json:"user_id"`
+				SessionID string      `json:"session_id"`
+			}
+			decodeBody(t, w, &body)
+			if body.User.ID != user.ID || body.User.Phone != user.Phone || body.User.Role != RoleModerator {
+				t.Errorf("currentUser = %+v, want %s with role %s", body.User, user.ID.Hex(), RoleModerator)
+			}
+			if body.UserID != user.ID.Hex() {
+				t.Errorf("currentUserID = %s, want %s", body.UserID, user.ID.Hex())
+			}
+			if body.SessionID == "" {
+				t.Error("claims carry no session")
+			}
+		})
+	}
+
+	t.Run("api key", func(t *testing.T) {
+		r, h := newTestServer(t)
+		user := createTestUser(t, h, "+77011234567", RoleUser)
+		token := loginTestUser(t, r, user.Phone)
+		w := doJSON(r, http.MethodPost, "/protected/api-keys", token, gin.H{"name": "CI", "scopes": []string{ScopePostsRead}})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create key: status %d, body %s", w.Code, w.Body.String())
+		}
+		var created struct {
+			Key    string `json:"key"`
+			APIKey APIKey `json:"api_key"`
+		}
+		decodeBody(t, w, &created)
+
+		withKey := func(method, path string) int {
+			req := httptest.NewRequest(method, path, strings.NewReader(`{"title":"Title","content":"Content"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "ApiKey "+created.Key)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		if got := withKey(http.MethodGet, "/protected/posts"); got != http.StatusOK {
+			t.Errorf("granted scope: status %d, want 200", got)
+		}
+		if got := withKey(http.MethodPost, "/protected/posts"); got != http.StatusForbidden {
+			t.Errorf("missing scope: status %d, want 403", got)
+		}
+		if got := withKey(http.MethodGet, "/protected/sessions"); got != http.StatusForbidden {
+			t.Errorf("route without API keys: status %d, want 403", got)
+		}
+
+		if w := doJSON(r, http.MethodDelete, "/protected/api-keys/"+created.APIKey.ID.Hex(), token, nil); w.Code != http.StatusOK {
+			t.Fatalf("revoke key: status %d, body %s", w.Code, w.Body.String())
+		}
+		if got := withKey(http.MethodGet, "/protected/posts"); got != http.StatusUnauthorized {
+			t.Errorf("revoked key: status %d, want 401", got)
+		}
+	})
+}
+
+// The token is rejected before AuthMiddleware looks up the user, so no user needs to exist
+func TestAuthMiddlewareRejectsMissingAndForgedTokens(t *testing.T) {
+	r := newCurrentUserRouter(NewHandler(newMemoryStores()))
+	now := time.Now()
+	token, err := jwtKeys.sign(&Claims{
+		Phone: "+77011234567",
+		StandardClaims: jwt.StandardClaims{
+			Id:        primitive.NewObjectID().Hex(),
+			Subject:   primitive.NewObjectID().Hex(),
+			Audience:  accessTokenAudience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{
+		"missing": "",
+		"garbage": "not-a-jwt",
+		"forged":  token[:len(token)-4] + "AAAA",
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status %d, want 401", w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareRejectsTokenWithoutSubject(t *testing.T) {
+	h := NewHandler(newMemoryStores())
+	r := newCurrentUserRouter(h)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	claims := parseTestToken(t, loginTestUser(t, r, user.Phone))
+	claims.Subject = ""
+	token, err := jwtKeys.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w := doJSON(r, http.MethodGet, "/me", token, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("status %d, want 401", w.Code)
+	}
+}
+
+func TestAuthMiddlewareRequiresPasswordChange(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	mustChange := true
+	if _, err := h.users.Update(context.Background(), user.ID, UserUpdate{MustChangePassword: &mustChange}); err != nil {
+		t.Fatal(err)
+	}
+	token := loginTestUser(t, r, user.Phone)
+
+	if w := doJSON(r, http.MethodGet, "/protected/", token, nil); w.Code != http.StatusForbidden {
+		t.Fatalf("status %d before the password change, want 403", w.Code)
+	}
+	w := doJSON(r, http.MethodPost, "/protected/password", token, gin.H{"current_password": testPassword, "new_password": "NewPassword456"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("change password: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, http.MethodGet, "/protected/", token, nil); w.Code != http.StatusOK {
+		t.Errorf("status %d after the password change, want 200", w.Code)
+	}
+}
+
+func TestCookieModeCSRF(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"phone":"+77011234567","password":"`+testPassword+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(authModeHeader, authModeCookie)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d, body %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	var csrfToken string
+	for _, cookie := range cookies {
+		if cookie.Name == csrfTokenCookie {
+			csrfToken = cookie.Value
+		}
+	}
+
+	send := func(method, path, csrf string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"phone":"`+user.Phone+`","password":"`+testPassword+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		if csrf != "" {
+			req.Header.Set(csrfTokenHeader, csrf)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		csrf         string
+		want         int
+	}{
+		{name: "safe method", method: http.MethodGet, path: "/protected/sessions", want: http.StatusOK},
+		{name: "missing token", method: http.MethodPost, path: "/protected/sessions/revoke-all", want: http.StatusForbidden},
+		{name: "wrong token", method: http.MethodPost, path: "/protected/sessions/revoke-all", csrf: "wrong", want: http.StatusForbidden},
+		{name: "valid token", method: http.MethodPost, path: "/protected/sessions/revoke-all", csrf: csrfToken, want: http.StatusOK},
+		{name: "refresh without token", method: http.MethodPost, path: "/token/refresh", want: http.StatusForbidden},
+		// Stale cookies must not break endpoints that do not authenticate with them
+		{name: "public endpoint", method: http.MethodPost, path: "/login", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := send(tt.method, tt.path, tt.csrf); got != tt.want {
+				t.Errorf("status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrentUserWithoutAuthMiddleware(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if user := currentUser(c); !user.ID.IsZero() || user.Phone != "" {
+		t.Errorf("currentUser = %+v, want an empty user", user)
+	}
+	if id := currentUserID(c); !id.IsZero() {
+		t.Errorf("currentUserID = %s, want zero", id.Hex())
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	h := NewHandler(newMemoryStores())
+	r := gin.New()
+	r.GET("/limited", h.RateLimitMiddleware("test", RateLimit{Requests: 2, Per: time.Minute}, KeyByIP), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for i, remaining := range []string{"1", "0"} {
+		w := doJSON(r, http.MethodGet, "/limited", "", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, w.Code)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: RateLimit-Limit %q, want 2", i+1, got)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != remaining {
+			t.Errorf("request %d: RateLimit-Remaining %q, want %s", i+1, got, remaining)
+		}
+		if w.Header().Get("RateLimit-Reset") == "" || w.Header().Get("Retry-After") != "" {
+			t.Errorf("request %d: headers %v, want RateLimit-Reset without Retry-After", i+1, w.Header())
+		}
+	}
+
+	// Two requests per minute refill one token every 30 seconds
+	w := doJSON(r, http.MethodGet, "/limited", "", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After %q, want 30", got)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining %q, want 0", got)
+	}
+	if got := w.Header().Get("RateLimit-Reset"); got != "60" {
+		t.Errorf("RateLimit-Reset %q, want 60", got)
+	}
+}
+
+func TestKeyByAPIKey(t *testing.T) {
+	key := func(authorization, phone string) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.Header.Set("Authorization", authorization)
+		if phone != "" {
+			c.Set("phone", phone)
+		}
+		return KeyByAPIKey(c)
+	}
+
+	first, second := key("ApiKey first", "+77011234567"), key("ApiKey second", "+77011234567")
+	if first == second {
+		t.Errorf("keys of one user share the limit %q", first)
+	}
+	if strings.Contains(first, "first") {
+		t.Errorf("limit key %q contains the API key", first)
+	}
+	if got := key("Bearer token", "+77011234567"); got != "phone:+77011234567" {
+		t.Errorf("access token: key %q, want the phone", got)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrationCollectionName - коллекция с записями о примененных миграциях MongoDB.
+const migrationCollectionName = "schema_migrations"
+
+// migrationLockCollectionName - коллекция с блокировкой, которую держит экземпляр, применяющий миграции.
+const migrationLockCollectionName = "schema_migrations_lock"
+
+// migrationLockTTL - срок блокировки миграций. Блокировка продлевается перед каждой миграцией,
+// а блокировку процесса, убитого во время миграции, следующий запуск забирает по истечении срока.
+const migrationLockTTL = 15 * time.Minute
+
+// mongoMigration - версия схемы MongoDB. Миграции ссылаются на коллекции по имени,
+// а не через глобальные переменные, чтобы их поведение не менялось вместе с кодом сервиса.
+type mongoMigration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error
+}
+
+// migrationState описывает миграцию для команды migrate status.
+type migrationState struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// collectionIndexes - индексы одной коллекции.
+type collectionIndexes struct {
+	collection string
+	indexes    []mongo.IndexModel
+}
+
+// createIndexes создает индексы. Индексы с теми же ключами и параметрами, созданные раньше, не меняются.
+func createIndexes(ctx context.Context, db *mongo.Database, sets []collectionIndexes) error {
+	for _, set := range sets {
+		if _, err := db.Collection(set.collection).Indexes().CreateMany(ctx, set.indexes); err != nil {
+			return fmt.Errorf("%s: %w", set.collection, err)
+		}
+	}
+	return nil
+}
+
+// dropIndexes удаляет индексы, созданные createIndexes, по их именам по умолчанию.
+func dropIndexes(ctx context.Context, db *mongo.Database, sets []collectionIndexes) error {
+	for _, set := range sets {
+		for _, index := range set.indexes {
+			name, err := indexName(index.Keys.(bson.D))
+			if err != nil {
+				return err
+			}
+			_, err = db.Collection(set.collection).Indexes().DropOne(ctx, name)
+			var cmdErr mongo.CommandError
+			// The index or the whole collection may already be gone
+			if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound")) {
+				return fmt.Errorf("%s: %w", set.collection, err)
+			}
+		}
+	}
+	return nil
+}
+
+// indexName возвращает имя индекса, которое MongoDB дает ему по умолчанию, например phone_1.
+func indexName(keys bson.D) (string, error) {
+	name := ""
+	for i, key := range keys {
+		if i > 0 {
+			name += "_"
+		}
+		name += fmt.Sprintf("%s_%v", key.Key, key.Value)
+	}
+	if name == "" {
+		return "", errors.New("index without keys")
+	}
+	return name, nil
+}
+
+// indexMigration создает миграцию, которая создает индексы при накате и удаляет при откате.
+func indexMigration(version int, name string, sets ...collectionIndexes) mongoMigration {
+	return mongoMigration{
+		Version: version,
+		Name:    name,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			return createIndexes(ctx, db, sets)
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			return dropIndexes(ctx, db, sets)
+		},
+	}
+}
+
+func uniqueIndex(key string) mongo.IndexModel {
+	return mongo.IndexModel{Keys: bson.D{{Key: key, Value: 1}}, Options: options.Index().SetUnique(true)}
+}
+
+func ascendingIndex(key string) mongo.IndexModel {
+	return mongo.IndexModel{Keys: bson.D{{Key: key, Value: 1}}}
+}
+
+func descendingIndex(key string) mongo.IndexModel {
+	return mongo.IndexModel{Keys: bson.D{{Key: key, Value: -1}}}
+}
+
+// ttlIndex удаляет документ через after после времени в поле key.
+func ttlIndex(key string, after time.Duration) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    bson.D{{Key: key, Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(after.Seconds())),
+	}
+}
+
+// legacyPhone - телефон пользователя в том виде, в котором он записан в базе.
+type legacyPhone struct {
+	ID    primitive.ObjectID `bson:"_id"`
+	Phone string             `bson:"phone"`
+}
+
+// planPhoneNormalization возвращает новые номера для телефонов, записанных не в формате E.164.
+// Номера, которые не удается разобрать, не меняются и попадают в лог. Если после нормализации
+// у нескольких пользователей оказывается один номер, возвращается ошибка со списком таких
+// пользователей: их нужно объединить или удалить вручную и повторить migrate up.
+func planPhoneNormalization(users []legacyPhone) (map[string]string, error) {
+	changes := map[string]string{}
+	owners := map[string][]legacyPhone{}
+	for _, user := range users {
+		phone, err := normalizePhone(user.Phone)
+		if err != nil {
+			log.Printf("User %s has phone %q that cannot be normalized, left unchanged", user.ID.Hex(), user.Phone)
+			phone = user.Phone
+		}
+		if phone != user.Phone {
+			changes[user.Phone] = phone
+		}
+		owners[phone] = append(owners[phone], user)
+	}
+
+	var duplicates []string
+	for phone, users := range owners {
+		if len(users) < 2 {
+			continue
+		}
+		accounts := make([]string, 0, len(users))
+		for _, user := range users {
+			accounts = append(accounts, fmt.Sprintf("%s (%q)", user.ID.Hex(), user.Phone))
+		}
+		duplicates = append(duplicates, phone+": "+strings.Join(accounts, ", "))
+	}
+	if len(duplicates) > 0 {
+		sort.Strings(duplicates)
+		return nil, fmt.Errorf("users share a phone after normalization, merge or delete them and run migrate up again:\n  %s",
+			strings.Join(duplicates, "\n  "))
+	}
+	return changes, nil
+}
+
+// normalizeUserPhones приводит телефоны пользователей к формату E.164 вместе с телефонами
+// их сессий и refresh-токенов. Ничего не меняет, если найдены дубликаты.
+func normalizeUserPhones(ctx context.Context, db *mongo.Database) error {
+	cursor, err := db.Collection("users").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"phone": 1}))
+	if err != nil {
+		return err
+	}
+	var users []legacyPhone
+	if err := cursor.All(ctx, &users); err != nil {
+		return err
+	}
+	changes, err := planPhoneNormalization(users)
+	if err != nil {
+		return err
+	}
+
+	for from, to := range changes {
+		for _, collection := range []string{"users", "sessions", "refresh_tokens"} {
+			if _, err := db.Collection(collection).UpdateMany(ctx, bson.M{"phone": from}, bson.M{"$set": bson.M{"phone": to}}); err != nil {
+				return fmt.Errorf("%s: %w", collection, err)
+			}
+		}
+	}
+	if len(changes) > 0 {
+		log.Printf("Normalized %d user phones", len(changes))
+	}
+	return nil
+}
+
+// mongoMigrations - миграции MongoDB в порядке применения. Примененные миграции не меняются,
+// изменения схемы добавляются новыми версиями.
+var mongoMigrations = []mongoMigration{
+	// Phones stored before E.164 normalization must be rewritten before they become unique
+	{
+		Version: 1,
+		Name:    "normalize_user_phones",
+		Up:      normalizeUserPhones,
+		// The original spelling of the numbers is not kept, there is nothing to restore
+		Down: func(context.Context, *mongo.Database) error { return nil },
+	},
+	indexMigration(2, "create_users_phone_index",
+		collectionIndexes{"users", []mongo.IndexModel{uniqueIndex("phone")}},
+	),
+	indexMigration(3, "create_posts_indexes",
+		collectionIndexes{"posts", []mongo.IndexModel{ascendingIndex("author_id"), descendingIndex("date")}},
+	),
+	indexMigration(4, "create_lookup_indexes",
+		collectionIndexes{"revoked_tokens", []mongo.IndexModel{uniqueIndex("jti")}},
+		collectionIndexes{"webauthn_credentials", []mongo.IndexModel{uniqueIndex("credential_id"), ascendingIndex("user_id")}},
+		collectionIndexes{"webauthn_ceremonies", []mongo.IndexModel{uniqueIndex("challenge")}},
+		collectionIndexes{"api_keys", []mongo.IndexModel{uniqueIndex("key_hash"), ascendingIndex("user_id")}},
+	),
+	// Expired auth artifacts are removed by MongoDB instead of piling up forever
+	indexMigration(5, "create_ttl_indexes",
+		collectionIndexes{"refresh_tokens", []mongo.IndexModel{ttlIndex("expires_at", 0)}},
+		collectionIndexes{"revoked_tokens", []mongo.IndexModel{ttlIndex("expires_at", 0)}},
+		collectionIndexes{"otp_codes", []mongo.IndexModel{ttlIndex("expires_at", 0)}},
+		collectionIndexes{"oauth_codes", []mongo.IndexModel{ttlIndex("expires_at", 0)}},
+		collectionIndexes{"login_attempts", []mongo.IndexModel{ttlIndex("expires_at", 0)}},
+		collectionIndexes{"rate_limits", []mongo.IndexModel{ttlIndex("expires_at", 0)}},
+		collectionIndexes{"webauthn_ceremonies", []mongo.IndexModel{ttlIndex("expires_at", 0)}},
+		// A session idle for longer than a refresh token lives (30 days) can no longer be resumed
+		collectionIndexes{"sessions", []mongo.IndexModel{ttlIndex("last_seen_at", 30*24*time.Hour)}},
+	),
+}
+
+// appliedMigrations возвращает записи о примененных миграциях по версиям.
+func appliedMigrations(ctx context.Context, db *mongo.Database) (map[int]MigrationRecord, error) {
+	cursor, err := db.Collection(migrationCollectionName).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []MigrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]MigrationRecord, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// mongoMigrationLock - блокировка миграций MongoDB, которую держит один экземпляр сервиса.
+type mongoMigrationLock struct {
+	collection *mongo.Collection
+	owner      primitive.ObjectID
+}
+
+// lockMongoMigrations берет блокировку миграций или возвращает ошибку, если ее держит другой экземпляр.
+func lockMongoMigrations(ctx context.Context, db *mongo.Database) (*mongoMigrationLock, error) {
+	lock := &mongoMigrationLock{collection: db.Collection(migrationLockCollectionName), owner: primitive.NewObjectID()}
+	if err := lock.extend(ctx); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// extend продлевает блокировку. Фильтр не находит блокировку, которую держит другой экземпляр,
+// и тогда upsert завершается ошибкой дубликата ключа.
+func (l *mongoMigrationLock) extend(ctx context.Context) error {
+	now := time.Now()
+	_, err := l.collection.UpdateOne(ctx,
+		bson.M{"_id": "migrations", "$or": bson.A{bson.M{"owner": l.owner}, bson.M{"expires_at": bson.M{"$lt": now}}}},
+		bson.M{"$set": bson.M{"owner": l.owner, "expires_at": now.Add(migrationLockTTL)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.New("MongoDB migrations are being applied by another instance")
+	}
+	return err
+}
+
+// release снимает блокировку, если ее все еще держит этот экземпляр.
+func (l *mongoMigrationLock) release(ctx context.Context) {
+	if _, err := l.collection.DeleteOne(ctx, bson.M{"_id": "migrations", "owner": l.owner}); err != nil {
+		log.Println("Failed to release the MongoDB migration lock:", err)
+	}
+}
+
+// migrateMongoUp применяет еще не примененные миграции MongoDB по порядку под блокировкой миграций.
+// Миграция отмечается примененной только после успешного Up. Если процесс убит посреди миграции,
+// следующий запуск применяет ее заново, поэтому Up должны быть идемпотентными.
+func migrateMongoUp(ctx context.Context, db *mongo.Database) error {
+	lock, err := lockMongoMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer lock.release(ctx)
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	for _, migration := range mongoMigrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := lock.extend(ctx); err != nil {
+			return err
+		}
+		if err := migration.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		record := MigrationRecord{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+		if _, err := db.Collection(migrationCollectionName).InsertOne(ctx, record); err != nil {
+			return err
+		}
+		log.Printf("Applied MongoDB migration %d_%s", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+// migrateMongoDown откатывает последнюю примененную миграцию MongoDB под блокировкой миграций.
+func migrateMongoDown(ctx context.Context, db *mongo.Database) error {
+	lock, err := lockMongoMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer lock.release(ctx)
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	for i := len(mongoMigrations) - 1; i >= 0; i-- {
+		migration := mongoMigrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := migration.Down(ctx, db); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if _, err := db.Collection(migrationCollectionName).DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+			return err
+		}
+		log.Printf("Reverted MongoDB migration %d_%s", migration.Version, migration.Name)
+		return nil
+	}
+	log.Println("No MongoDB migrations to revert")
+	return nil
+}
+
+// mongoMigrationStatus возвращает все миграции MongoDB с временем применения.
+func mongoMigrationStatus(ctx context.Context, db *mongo.Database) ([]migrationState, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]migrationState, 0, len(mongoMigrations))
+	for _, migration := range mongoMigrations {
+		states = append(states, migrationState{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: applied[migration.Version].AppliedAt,
+		})
+	}
+	return states, nil
+}
+
+// pendingMigrations возвращает ошибку, если не все миграции применены.
+// Принимает результат mongoMigrationStatus или postgresMigrationStatus.
+func pendingMigrations(states []migrationState, err error) error {
+	if err != nil {
+		return err
+	}
+	var pending []string
+	for _, state := range states {
+		if state.AppliedAt.IsZero() {
+			pending = append(pending, fmt.Sprintf("%d_%s", state.Version, state.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("schema is out of date, pending migrations: %s; run migrate up or set AUTO_MIGRATE=true", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// printMigrationStatus печатает таблицу миграций.
+func printMigrationStatus(title string, states []migrationState) {
+	fmt.Println(title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, state := range states {
+		appliedAt := "pending"
+		if !state.AppliedAt.IsZero() {
+			appliedAt = state.AppliedAt.Local().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", state.Version, state.Name, appliedAt)
+	}
+	w.Flush()
+}
+
+// runMigrateCommand выполняет команду migrate up|down|status для базы STORAGE_BACKEND.
+// down откатывает последнюю примененную миграцию.
+func runMigrateCommand(args []string) {
+	if len(args) != 1 {
+		log.Fatal("usage: migrate up|down|status")
+	}
+	// Exit only after migrate has released the lock and closed the connection
+	if err := migrate(context.Background(), args[0]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// migrate выполняет команду migrate command и закрывает соединение с базой.
+func migrate(ctx context.Context, command string) error {
+	var (
+		up     func() error
+		down   func() error
+		status func() ([]migrationState, error)
+		title  string
+	)
+	switch config.StorageBackend {
+	case "mongo":
+		initDB()
+		defer database.Client().Disconnect(ctx)
+		title = "MongoDB"
+		up = func() error { return migrateMongoUp(ctx, database) }
+		down = func() error { return migrateMongoDown(ctx, database) }
+		status = func() ([]migrationState, error) { return mongoMigrationStatus(ctx, database) }
+	case "postgres":
+		postgres := openPostgres()
+		defer postgres.Close()
+		title = "PostgreSQL"
+		up = func() error { return migratePostgres(ctx, postgres) }
+		down = func() error { return rollbackPostgres(ctx, postgres) }
+		status = func() ([]migrationState, error) { return postgresMigrationStatus(ctx, postgres) }
+	default:
+		return fmt.Errorf("STORAGE_BACKEND=%s has no migrations", config.StorageBackend)
+	}
+
+	switch command {
+	case "up":
+		return up()
+	case "down":
+		return down()
+	case "status":
+		states, err := status()
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(title, states)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", command)
+	}
+}
@@ -0,0 +1,29 @@
+CREATE TABLE users (
+    id                   CHAR(24) PRIMARY KEY,
+    name                 TEXT    NOT NULL DEFAULT '',
+    phone                TEXT    NOT NULL,
+    phone_verified       BOOLEAN NOT NULL DEFAULT FALSE,
+    password             TEXT    NOT NULL DEFAULT '',
+    role                 TEXT    NOT NULL DEFAULT '',
+    disabled             BOOLEAN NOT NULL DEFAULT FALSE,
+    must_change_password BOOLEAN NOT NULL DEFAULT FALSE,
+    token_version        INTEGER NOT NULL DEFAULT 0,
+    totp_enabled         BOOLEAN NOT NULL DEFAULT FALSE,
+    totp_secret          TEXT    NOT NULL DEFAULT '',
+    totp_pending_url     TEXT    NOT NULL DEFAULT '',
+    totp_last_step       BIGINT  NOT NULL DEFAULT 0,
+    recovery_codes       TEXT[]  NOT NULL DEFAULT '{}',
+    CONSTRAINT users_phone_key UNIQUE (phone)
+);
+
+CREATE TABLE posts (
+    id        CHAR(24)    PRIMARY KEY,
+    title     TEXT        NOT NULL DEFAULT '',
+    text      TEXT        NOT NULL DEFAULT '',
+    image     TEXT        NOT NULL DEFAULT '',
+    author    TEXT        NOT NULL DEFAULT '',
+    date      TIMESTAMPTZ NOT NULL,
+    author_id CHAR(24)    NOT NULL REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX posts_author_id_idx ON posts (author_id);
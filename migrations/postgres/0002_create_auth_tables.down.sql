@@ -0,0 +1,11 @@
+DROP TABLE oauth_codes;
+DROP TABLE oauth_clients;
+DROP TABLE webauthn_ceremonies;
+DROP TABLE webauthn_credentials;
+DROP TABLE api_keys;
+DROP TABLE rate_limits;
+DROP TABLE login_attempts;
+DROP TABLE otp_codes;
+DROP TABLE revoked_tokens;
+DROP TABLE refresh_tokens;
+DROP TABLE sessions;
@@ -0,0 +1,140 @@
+CREATE TABLE sessions (
+    id           CHAR(24)    PRIMARY KEY,
+    phone        TEXT        NOT NULL,
+    device       TEXT        NOT NULL DEFAULT '',
+    ip           TEXT        NOT NULL DEFAULT '',
+    user_agent   TEXT        NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_seen_at TIMESTAMPTZ NOT NULL,
+    revoked      BOOLEAN     NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX sessions_phone_idx ON sessions (phone);
+CREATE INDEX sessions_last_seen_at_idx ON sessions (last_seen_at);
+
+CREATE TABLE refresh_tokens (
+    id         CHAR(24)    PRIMARY KEY,
+    hash       TEXT        NOT NULL,
+    phone      TEXT        NOT NULL,
+    family_id  TEXT        NOT NULL,
+    used       BOOLEAN     NOT NULL DEFAULT FALSE,
+    revoked    BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    CONSTRAINT refresh_tokens_hash_key UNIQUE (hash)
+);
+
+CREATE INDEX refresh_tokens_phone_idx ON refresh_tokens (phone);
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+
+CREATE TABLE revoked_tokens (
+    jti        TEXT        PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+
+CREATE TABLE otp_codes (
+    phone      TEXT        NOT NULL,
+    purpose    TEXT        NOT NULL,
+    code_hash  TEXT        NOT NULL,
+    attempts   INTEGER     NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (phone, purpose)
+);
+
+CREATE INDEX otp_codes_expires_at_idx ON otp_codes (expires_at);
+
+CREATE TABLE login_attempts (
+    key          TEXT        PRIMARY KEY,
+    failures     INTEGER     NOT NULL DEFAULT 0,
+    last_failure TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ,
+    expires_at   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX login_attempts_expires_at_idx ON login_attempts (expires_at);
+
+CREATE TABLE rate_limits (
+    key        TEXT             PRIMARY KEY,
+    tokens     DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ      NOT NULL,
+    expires_at TIMESTAMPTZ      NOT NULL
+);
+
+CREATE INDEX rate_limits_expires_at_idx ON rate_limits (expires_at);
+
+CREATE TABLE api_keys (
+    id           CHAR(24)    PRIMARY KEY,
+    user_id      CHAR(24)    NOT NULL,
+    name         TEXT        NOT NULL DEFAULT '',
+    prefix       TEXT        NOT NULL DEFAULT '',
+    key_hash     TEXT        NOT NULL,
+    scopes       TEXT[]      NOT NULL DEFAULT '{}',
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash)
+);
+
+CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);
+
+CREATE TABLE webauthn_credentials (
+    id               CHAR(24)    PRIMARY KEY,
+    user_id          CHAR(24)    NOT NULL,
+    name             TEXT        NOT NULL DEFAULT '',
+    credential_id    BYTEA       NOT NULL,
+    public_key       BYTEA       NOT NULL,
+    attestation_type TEXT        NOT NULL DEFAULT '',
+    transports       TEXT[]      NOT NULL DEFAULT '{}',
+    aaguid           BYTEA,
+    sign_count       BIGINT      NOT NULL DEFAULT 0,
+    attachment       TEXT        NOT NULL DEFAULT '',
+    backup_eligible  BOOLEAN     NOT NULL DEFAULT FALSE,
+    backup_state     BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at       TIMESTAMPTZ NOT NULL,
+    last_used_at     TIMESTAMPTZ,
+    CONSTRAINT webauthn_credentials_credential_id_key UNIQUE (credential_id)
+);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+CREATE TABLE webauthn_ceremonies (
+    challenge  TEXT        PRIMARY KEY,
+    purpose    TEXT        NOT NULL,
+    user_id    CHAR(24),
+    session    BYTEA       NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX webauthn_ceremonies_expires_at_idx ON webauthn_ceremonies (expires_at);
+
+CREATE TABLE oauth_clients (
+    id            CHAR(24)    PRIMARY KEY,
+    client_id     TEXT        NOT NULL,
+    secret_hash   TEXT        NOT NULL DEFAULT '',
+    name          TEXT        NOT NULL DEFAULT '',
+    redirect_uris TEXT[]      NOT NULL DEFAULT '{}',
+    grant_types   TEXT[]      NOT NULL DEFAULT '{}',
+    scopes        TEXT[]      NOT NULL DEFAULT '{}',
+    confidential  BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at    TIMESTAMPTZ NOT NULL,
+    CONSTRAINT oauth_clients_client_id_key UNIQUE (client_id)
+);
+
+CREATE TABLE oauth_codes (
+    hash           TEXT        PRIMARY KEY,
+    client_id      TEXT        NOT NULL,
+    user_id        CHAR(24)    NOT NULL,
+    redirect_uri   TEXT        NOT NULL DEFAULT '',
+    scope          TEXT        NOT NULL DEFAULT '',
+    nonce          TEXT        NOT NULL DEFAULT '',
+    code_challenge TEXT        NOT NULL DEFAULT '',
+    auth_time      TIMESTAMPTZ NOT NULL,
+    used           BOOLEAN     NOT NULL DEFAULT FALSE,
+    expires_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX oauth_codes_expires_at_idx ON oauth_codes (expires_at);
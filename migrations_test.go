@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPlanPhoneNormalization(t *testing.T) {
+	users := []legacyPhone{
+		{ID: primitive.NewObjectID(), Phone: "+77011234567"},
+		{ID: primitive.NewObjectID(), Phone: "8 (702) 123-45-67"},
+		{ID: primitive.NewObjectID(), Phone: "not a phone"},
+	}
+	changes, err := planPhoneNormalization(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"8 (702) 123-45-67": "+77021234567"}
+	if len(changes) != len(want) || changes["8 (702) 123-45-67"] != want["8 (702) 123-45-67"] {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+
+	duplicate := legacyPhone{ID: primitive.NewObjectID(), Phone: "87011234567"}
+	_, err = planPhoneNormalization(append(users, duplicate))
+	if err == nil {
+		t.Fatal("duplicate phones were not reported")
+	}
+	for _, part := range []string{"+77011234567", users[0].ID.Hex(), duplicate.ID.Hex()} {
+		if !strings.Contains(err.Error(), part) {
+			t.Errorf("error %q does not mention %s", err, part)
+		}
+	}
+}
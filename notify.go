@@ -0,0 +1,19 @@
+package main
+
+import "context"
+
+// Notifier доставляет пользователю служебные уведомления: коды сброса пароля, предупреждения о безопасности и т.п.
+type Notifier interface {
+	Notify(ctx context.Context, user User, subject, message string) error
+}
+
+// smsNotifier доставляет уведомления по SMS на номер пользователя. Тема в SMS не передается.
+type smsNotifier struct {
+	sender SMSSender
+}
+
+func (n smsNotifier) Notify(ctx context.Context, user User, _, message string) error {
+	return n.sender.Send(ctx, user.Phone, message)
+}
+
+var notifier Notifier
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	authorizationCodeTTL = 10 * time.Minute
+
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopePhone   = "phone"
+)
+
+var (
+	errInvalidClient = errors.New("invalid client")
+	errInvalidGrant  = errors.New("invalid grant")
+)
+
+// OAuthClaims описывает access-токен, выданный клиенту OAuth 2.0.
+type OAuthClaims struct {
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id"`
+	jwt.StandardClaims
+}
+
+// IDTokenClaims описывает ID-токен OpenID Connect.
+type IDTokenClaims struct {
+	Nonce       string `json:"nonce,omitempty"`
+	AuthTime    int64  `json:"auth_time,omitempty"`
+	Name        string `json:"name,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+	jwt.StandardClaims
+}
+
+// oauthIssuer возвращает идентификатор издателя токенов из OAUTH_ISSUER.
+func oauthIssuer() string {
+	return strings.TrimSuffix(config.OAuthIssuer, "/")
+}
+
+// oauthError отвечает ошибкой в формате RFC 6749.
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// hasScope проверяет наличие области доступа в строке scope, разделенной пробелами.
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedScope проверяет, что все запрошенные области доступа разрешены клиенту.
+func allowedScope(client OAuthClient, scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !containsString(client.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsString проверяет, входит ли строка в срез.
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyPKCE сравнивает code_verifier с сохраненным code_challenge по методу S256.
+func verifyPKCE(challenge, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// authenticateClient проверяет учетные данные клиента из HTTP Basic или полей формы.
+// Публичные клиенты проходят проверку без секрета.
+func (h *Handler) authenticateClient(c *gin.Context) (OAuthClient, error) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+	if clientID == "" {
+		return OAuthClient{}, errInvalidClient
+	}
+
+	client, err := h.oauthClients.FindByClientID(context.Background(), clientID)
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	if !client.Confidential {
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return OAuthClient{}, errInvalidClient
+	}
+	return client, nil
+}
+
+// issueOAuthAccessToken выпускает access-токен OAuth 2.0 для субъекта и клиента.
+func issueOAuthAccessToken(subject, clientID, scope string) (string, error) {
+	jti, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return jwtKeys.sign(&OAuthClaims{
+		Scope:    scope,
+		ClientID: clientID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    oauthIssuer(),
+			Subject:   subject,
+			Audience:  clientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+		},
+	})
+}
+
+// issueIDToken выпускает ID-токен OpenID Connect с утверждениями, разрешенными областями доступа.
+func issueIDToken(user User, code AuthorizationCode) (string, error) {
+	// The ID token intentionally expires with the access token issued with it: clients validate it
+	// once right after the code exchange, so it needs no lifetime of its own
+	now := time.Now()
+	claims := &IDTokenClaims{
+		Nonce:    code.Nonce,
+		AuthTime: code.AuthTime.Unix(),
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    oauthIssuer(),
+			Subject:   user.ID.Hex(),
+			Audience:  code.ClientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+		},
+	}
+	if hasScope(code.Scope, scopeProfile) {
+		claims.Name = user.Name
+	}
+	if hasScope(code.Scope, scopePhone) {
+		claims.PhoneNumber = user.Phone
+	}
+	return jwtKeys.sign(claims)
+}
+
+// parseOAuthAccessToken проверяет подпись, срок действия и отзыв access-токена OAuth 2.0.
+func (h *Handler) parseOAuthAccessToken(ctx context.Context, tokenString string) (*OAuthClaims, error) {
+	claims := &OAuthClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, jwtKeys.keyFunc)
+	if err != nil || !token.Valid || claims.ClientID == "" {
+		return nil, errInvalidGrant
+	}
+
+	revoked, err := h.revokedTokens.IsRevoked(ctx, claims.Id)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errInvalidGrant
+	}
+	return claims, nil
+}
+
+// CreateOAuthClient godoc
+//
+//	@Summary		Register an OAuth client
+//	@Description	Register an application that signs users in through this server. The client secret is returned only once.
+//	@Tags			OAuth
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			client	body		CreateOAuthClientRequest	true	"Client details"
+//	@Success		200		{object}	map[string]interface{}		"Registered client"
+//	@Failure		400		{object}	map[string]string			"Invalid input"
+//	@Failure		500		{object}	map[string]string			"Failed to register client"
+//	@Router			/admin/oauth/clients [post]
+func (h *Handler) CreateOAuthClient(c *gin.Context) {
+	var req CreateOAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, grant := range req.GrantTypes {
+		if grant != "authorization_code" && grant != "client_credentials" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported grant type " + grant})
+			return
+		}
+	}
+	if containsString(req.GrantTypes, "client_credentials") && !req.Confidential {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Client credentials grant requires a confidential client"})
+		return
+	}
+	for _, uri := range req.RedirectURIs {
+		if u, err := url.Parse(uri); err != nil || !u.IsAbs() || u.Fragment != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid redirect URI " + uri})
+			return
+		}
+	}
+
+	clientID, err := generateOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register client"})
+		return
+	}
+	client := OAuthClient{
+		ID:           primitive.NewObjectID(),
+		ClientID:     clientID,
+		Name:         req.Name,
+		RedirectURIs: req.RedirectURIs,
+		GrantTypes:   req.GrantTypes,
+		Scopes:       req.Scopes,
+		Confidential: req.Confidential,
+		CreatedAt:    time.Now(),
+	}
+
+	response := gin.H{"client": client}
+	if client.Confidential {
+		secret, err := generateOpaqueToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register client"})
+			return
+		}
+		client.SecretHash = hashToken(secret)
+		response["client_secret"] = secret
+	}
+
+	if err := h.oauthClients.Create(context.Background(), client); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register client"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Authorize godoc
+//
+//	@Summary		OAuth authorization endpoint
+//	@Description	Issue an authorization code for the signed-in user. Returns the client redirect URI with the code (or an error) instead of redirecting, so the front end can show a consent screen.
+//	@Tags			OAuth
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			response_type			query		string				true	"Must be code"
+//	@Param			client_id				query		string				true	"Client ID"
+//	@Param			redirect_uri			query		string				true	"Registered redirect URI"
+//	@Param			scope					query		string				false	"Space separated scopes"
+//	@Param			state					query		string				false	"Opaque client state"
+//	@Param			nonce					query		string				false	"OpenID Connect nonce"
+//	@Param			code_challenge			query		string				false	"PKCE challenge, required for public clients"
+//	@Param			code_challenge_method	query		string				false	"PKCE method, only S256"
+//	@Success		200						{object}	map[string]string	"Redirect URI"
+//	@Failure		400						{object}	map[string]string	"Invalid client or redirect URI"
+//	@Router			/oauth/authorize [get]
+func (h *Handler) Authorize(c *gin.Context) {
+	var req AuthorizeRequest
+	if err := c.ShouldBind(&req); err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	client, err := h.oauthClients.FindByClientID(context.Background(), req.ClientID)
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_client", "Unknown client")
+		return
+	}
+	// Never redirect to an unregistered URI
+	if !containsString(client.RedirectURIs, req.RedirectURI) {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "Redirect URI is not registered")
+		return
+	}
+
+	redirect := func(params url.Values) {
+		if req.State != "" {
+			params.Set("state", req.State)
+		}
+		target, _ := url.Parse(req.RedirectURI)
+		query := target.Query()
+		for key, values := range params {
+			query[key] = values
+		}
+		target.RawQuery = query.Encode()
+		c.JSON(http.StatusOK, gin.H{"redirect_uri": target.String()})
+	}
+	fail := func(code, description string) {
+		redirect(url.Values{"error": {code}, "error_description": {description}})
+	}
+
+	switch {
+	case req.ResponseType != "code":
+		fail("unsupported_response_type", "Only the code response type is supported")
+		return
+	case !containsString(client.GrantTypes, "authorization_code"):
+		fail("unauthorized_client", "Client may not use the authorization code grant")
+		return
+	case !allowedScope(client, req.Scope):
+		fail("invalid_scope", "Requested scope is not allowed for this client")
+		return
+	case req.CodeChallenge == "" && !client.Confidential:
+		fail("invalid_request", "PKCE code challenge is required for public clients")
+		return
+	case req.CodeChallenge != "" && req.CodeChallengeMethod != "S256":
+		fail("invalid_request", "Only the S256 code challenge method is supported")
+		return
+	}
+
+	user := currentUser(c)
+
+	code, err := generateOpaqueToken()
+	if err != nil {
+		fail("server_error", "Failed to issue authorization code")
+		return
+	}
+	// Refresh reissues the access token, the session keeps the time of the login itself
+	claims := c.MustGet("claims").(*Claims)
+	session, err := h.sessions.Find(context.Background(), sessionObjectID(claims.SessionID), user.Phone)
+	if err != nil {
+		fail("server_error", "Failed to issue authorization code")
+		return
+	}
+	now := time.Now()
+	err = h.oauthCodes.Create(context.Background(), AuthorizationCode{
+		Hash:          hashToken(code),
+		ClientID:      client.ClientID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         req.Scope,
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      session.CreatedAt,
+		ExpiresAt:     now.Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		fail("server_error", "Failed to issue authorization code")
+		return
+	}
+
+	redirect(url.Values{"code": {code}})
+}
+
+// Token godoc
+//
+//	@Summary		OAuth token endpoint
+//	@Description	Exchange an authorization code (with PKCE verifier) or client credentials for an access token. The openid scope adds an ID token.
+//	@Tags			OAuth
+//	@Accept			x-www-form-urlencoded
+//	@Produce		json
+//	@Param			grant_type		formData	string					true	"authorization_code or client_credentials"
+//	@Param			code			formData	string					false	"Authorization code"
+//	@Param			redirect_uri	formData	string					false	"Redirect URI used in the authorization request"
+//	@Param			code_verifier	formData	string					false	"PKCE verifier"
+//	@Param			scope			formData	string					false	"Scope for client credentials"
+//	@Param			client_id		formData	string					false	"Client ID when not using HTTP Basic"
+//	@Param			client_secret	formData	string					false	"Client secret when not using HTTP Basic"
+//	@Success		200				{object}	map[string]interface{}	"Token response"
+//	@Failure		400				{object}	map[string]string		"Invalid grant"
+//	@Failure		401				{object}	map[string]string		"Invalid client"
+//	@Router			/oauth/token [post]
+func (h *Handler) Token(c *gin.Context) {
+	client, err := h.authenticateClient(c)
+	if err != nil {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	grantType := c.PostForm("grant_type")
+	if !containsString(client.GrantTypes, grantType) {
+		oauthError(c, http.StatusBadRequest, "unauthorized_client", "Client may not use this grant type")
+		return
+	}
+
+	switch grantType {
+	case "authorization_code":
+		h.exchangeAuthorizationCode(c, client)
+	case "client_credentials":
+		scope := c.PostForm("scope")
+		if !allowedScope(client, scope) || hasScope(scope, scopeOpenID) {
+			oauthError(c, http.StatusBadRequest, "invalid_scope", "Requested scope is not allowed for this client")
+			return
+		}
+		accessToken, err := issueOAuthAccessToken(client.ClientID, client.ClientID, scope)
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", "Failed to issue token")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int(accessTokenTTL.Seconds()),
+			"scope":        scope,
+		})
+	default:
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant type")
+	}
+}
+
+// exchangeAuthorizationCode обменивает одноразовый код авторизации на access-токен и, при scope openid, ID-токен.
+func (h *Handler) exchangeAuthorizationCode(c *gin.Context, client OAuthClient) {
+	code, err := h.oauthCodes.Use(context.Background(), hashToken(c.PostForm("code")), client.ClientID)
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+	if time.Now().After(code.ExpiresAt) || code.RedirectURI != c.PostForm("redirect_uri") {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "Invalid authorization code")
+		return
+	}
+	if code.CodeChallenge != "" && !verifyPKCE(code.CodeChallenge, c.PostForm("code_verifier")) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "Invalid code verifier")
+		return
+	}
+
+	user, err := h.users.FindByID(context.Background(), code.UserID)
+	if err != nil || user.Disabled {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "User is not available")
+		return
+	}
+
+	accessToken, err := issueOAuthAccessToken(user.ID.Hex(), client.ClientID, code.Scope)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		return
+	}
+	response := gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(accessTokenTTL.Seconds()),
+		"scope":        code.Scope,
+	}
+	if hasScope(code.Scope, scopeOpenID) {
+		idToken, err := issueIDToken(user, code)
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", "Failed to issue token")
+			return
+		}
+		response["id_token"] = idToken
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// UserInfo godoc
+//
+//	@Summary		OpenID Connect userinfo
+//	@Description	Return claims about the user the OAuth access token was issued for
+//	@Tags			OAuth
+//	@Produce		json
+//	@Param			Authorization	header		string					true	"Bearer OAuth access token"
+//	@Success		200				{object}	map[string]interface{}	"User claims"
+//	@Failure		401				{object}	map[string]string		"Invalid token"
+//	@Router			/userinfo [get]
+func (h *Handler) UserInfo(c *gin.Context) {
+	tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	claims, err := h.parseOAuthAccessToken(context.Background(), tokenString)
+	if err != nil || !hasScope(claims.Scope, scopeOpenID) {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_token", "Invalid access token")
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		oauthError(c, http.StatusUnauthorized, "invalid_token", "Invalid access token")
+		return
+	}
+	user, err := h.users.FindByID(context.Background(), userID)
+	if err != nil || user.Disabled {
+		oauthError(c, http.StatusUnauthorized, "invalid_token", "Invalid access token")
+		return
+	}
+
+	info := gin.H{"sub": user.ID.Hex()}
+	if hasScope(claims.Scope, scopeProfile) {
+		info["name"] = user.Name
+	}
+	if hasScope(claims.Scope, scopePhone) {
+		info["phone_number"] = user.Phone
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// Introspect godoc
+//
+//	@Summary		OAuth token introspection
+//	@Description	Tell an authenticated confidential client whether an access token issued to it is active (RFC 7662). Tokens of other clients are reported as inactive.
+//	@Tags			OAuth
+//	@Accept			x-www-form-urlencoded
+//	@Produce		json
+//	@Param			token	formData	string					true	"Access token"
+//	@Success		200		{object}	map[string]interface{}	"Token state"
+//	@Failure		401		{object}	map[string]string		"Invalid or public client"
+//	@Router			/oauth/introspect [post]
+func (h *Handler) Introspect(c *gin.Context) {
+	// Public clients have no secret, anyone could introspect tokens in their name
+	client, err := h.authenticateClient(c)
+	if err != nil || !client.Confidential {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	// Other clients' tokens must not reveal their subjects and scopes
+	claims, err := h.parseOAuthAccessToken(context.Background(), c.PostForm("token"))
+	if err != nil || claims.ClientID != client.ClientID {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"active":     true,
+		"scope":      claims.Scope,
+		"client_id":  claims.ClientID,
+		"sub":        claims.Subject,
+		"iss":        claims.Issuer,
+		"exp":        claims.ExpiresAt,
+		"iat":        claims.IssuedAt,
+		"token_type": "Bearer",
+	})
+}
+
+// Revoke godoc
+//
+//	@Summary		OAuth token revocation
+//	@Description	Revoke an access token issued to the authenticated client (RFC 7009)
+//	@Tags			OAuth
+//	@Accept			x-www-form-urlencoded
+//	@Produce		json
+//	@Param			token	formData	string				true	"Access token"
+//	@Success		200		{object}	map[string]string	"Token revoked"
+//	@Failure		401		{object}	map[string]string	"Invalid client"
+//	@Router			/oauth/revoke [post]
+func (h *Handler) Revoke(c *gin.Context) {
+	client, err := h.authenticateClient(c)
+	if err != nil {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	// Unknown or foreign tokens are ignored, as required by RFC 7009
+	claims, err := h.parseOAuthAccessToken(context.Background(), c.PostForm("token"))
+	if err == nil && claims.ClientID == client.ClientID {
+		if err := h.revokedTokens.Revoke(context.Background(), claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", "Failed to revoke token")
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
+
+// OpenIDConfiguration godoc
+//
+//	@Summary		OpenID Connect discovery
+//	@Description	OpenID provider metadata
+//	@Tags			OAuth
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}	"Provider metadata"
+//	@Router			/.well-known/openid-configuration [get]
+func OpenIDConfiguration(c *gin.Context) {
+	issuer := oauthIssuer()
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"introspection_endpoint":                issuer + "/oauth/introspect",
+		"revocation_endpoint":                   issuer + "/oauth/revoke",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "client_credentials"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{jwtKeys.active.method.Alg()},
+		"scopes_supported":                      []string{scopeOpenID, scopeProfile, scopePhone},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "name", "phone_number"},
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testRedirectURI = "https://client.example/callback"
+
+// createTestClient регистрирует OAuth-клиента с секретом clientID+"-secret" и адресом возврата testRedirectURI.
+func createTestClient(t *testing.T, h *Handler, clientID string, confidential bool) {
+	t.Helper()
+	client := OAuthClient{
+		ID:           primitive.NewObjectID(),
+		ClientID:     clientID,
+		Name:         clientID,
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   []string{"authorization_code"},
+		Scopes:       []string{scopeOpenID, scopeProfile},
+		Confidential: confidential,
+	}
+	if confidential {
+		client.SecretHash = hashToken(clientID + "-secret")
+	}
+	if err := h.oauthClients.Create(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIntrospect(t *testing.T) {
+	r, h := newTestServer(t)
+	createTestClient(t, h, "owner", true)
+	createTestClient(t, h, "other", true)
+	createTestClient(t, h, "public", false)
+	token, err := issueOAuthAccessToken(primitive.NewObjectID().Hex(), "owner", scopeProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		clientID   string
+		wantStatus int
+		wantActive bool
+	}{
+		{clientID: "owner", wantStatus: http.StatusOK, wantActive: true},
+		{clientID: "other", wantStatus: http.StatusOK, wantActive: false},
+		{clientID: "public", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.clientID, func(t *testing.T) {
+			form := url.Values{"token": {token}}
+			req := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetBasicAuth(tt.clientID, tt.clientID+"-secret")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Active bool   `json:"active"`
+				Sub    string `json:"sub"`
+			}
+			decodeBody(t, w, &body)
+			if body.Active != tt.wantActive || (!tt.wantActive && body.Sub != "") {
+				t.Errorf("response %s, want active %v", w.Body.String(), tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestAuthorizeAuthTime(t *testing.T) {
+	r, h := newTestServer(t)
+	createTestClient(t, h, "owner", true)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	w := doJSON(r, http.MethodPost, "/login", "", gin.H{"phone": user.Phone, "password": testPassword})
+	var login struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decodeBody(t, w, &login)
+
+	// The user signed in an hour ago and has refreshed the access token since
+	sessions := h.sessions.(*memorySessionStore)
+	sessionID := sessionObjectID(parseTestToken(t, login.Token).SessionID)
+	loggedInAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	session := sessions.sessions[sessionID]
+	session.CreatedAt = loggedInAt
+	sessions.sessions[sessionID] = session
+	w = doJSON(r, http.MethodPost, "/token/refresh", "", gin.H{"refresh_token": login.RefreshToken})
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: status %d, body %s", w.Code, w.Body.String())
+	}
+	var refreshed struct {
+		Token string `json:"token"`
+	}
+	decodeBody(t, w, &refreshed)
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {"owner"},
+		"redirect_uri":  {testRedirectURI},
+		"scope":         {scopeOpenID},
+	}
+	w = doJSON(r, http.MethodGet, "/oauth/authorize?"+query.Encode(), refreshed.Token, nil)
+	var body struct {
+		RedirectURI string `json:"redirect_uri"`
+	}
+	decodeBody(t, w, &body)
+	redirect, err := url.Parse(body.RedirectURI)
+	if err != nil || redirect.Query().Get("code") == "" {
+		t.Fatalf("authorize: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	code, err := h.oauthCodes.Use(context.Background(), hashToken(redirect.Query().Get("code")), "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !code.AuthTime.Equal(loggedInAt) {
+		t.Errorf("auth_time = %s, want the login time %s", code.AuthTime, loggedInAt)
+	}
+}
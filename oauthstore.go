@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OAuthClientStore хранит зарегистрированных клиентов OAuth 2.0.
+type OAuthClientStore interface {
+	Create(ctx context.Context, client OAuthClient) error
+	// FindByClientID возвращает клиента или errInvalidClient.
+	FindByClientID(ctx context.Context, clientID string) (OAuthClient, error)
+}
+
+// AuthorizationCodeStore хранит хеши выданных кодов авторизации.
+type AuthorizationCodeStore interface {
+	Create(ctx context.Context, code AuthorizationCode) error
+	// Use помечает неиспользованный код клиента clientID использованным и возвращает его.
+	// Возвращает errInvalidGrant, если кода нет, он выдан другому клиенту или уже использован.
+	Use(ctx context.Context, hash, clientID string) (AuthorizationCode, error)
+}
+
+// mongoOAuthClientStore хранит клиентов в коллекции MongoDB.
+type mongoOAuthClientStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoOAuthClientStore) Create(ctx context.Context, client OAuthClient) error {
+	_, err := s.collection.InsertOne(ctx, client)
+	return err
+}
+
+func (s *mongoOAuthClientStore) FindByClientID(ctx context.Context, clientID string) (OAuthClient, error) {
+	var client OAuthClient
+	err := s.collection.FindOne(ctx, bson.M{"client_id": clientID}).Decode(&client)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return OAuthClient{}, errInvalidClient
+	}
+	return client, err
+}
+
+// mongoAuthorizationCodeStore хранит коды в коллекции MongoDB. Истекшие коды удаляет TTL-индекс.
+type mongoAuthorizationCodeStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoAuthorizationCodeStore) Create(ctx context.Context, code AuthorizationCode) error {
+	_, err := s.collection.InsertOne(ctx, code)
+	return err
+}
+
+func (s *mongoAuthorizationCodeStore) Use(ctx context.Context, hash, clientID string) (AuthorizationCode, error) {
+	var code AuthorizationCode
+	err := s.collection.FindOneAndUpdate(ctx,
+		bson.M{"hash": hash, "client_id": clientID, "used": false},
+		bson.M{"$set": bson.M{"used": true}},
+	).Decode(&code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return AuthorizationCode{}, errInvalidGrant
+	}
+	return code, err
+}
+
+// memoryOAuthClientStore хранит клиентов в памяти процесса по client_id.
+type memoryOAuthClientStore struct {
+	mu      sync.Mutex
+	clients map[string]OAuthClient
+}
+
+func newMemoryOAuthClientStore() *memoryOAuthClientStore {
+	return &memoryOAuthClientStore{clients: make(map[string]OAuthClient)}
+}
+
+func (s *memoryOAuthClientStore) Create(_ context.Context, client OAuthClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[client.ClientID] = client
+	return nil
+}
+
+func (s *memoryOAuthClientStore) FindByClientID(_ context.Context, clientID string) (OAuthClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[clientID]
+	if !ok {
+		return OAuthClient{}, errInvalidClient
+	}
+	return client, nil
+}
+
+// memoryAuthorizationCodeStore хранит коды в памяти процесса по хешу.
+type memoryAuthorizationCodeStore struct {
+	mu    sync.Mutex
+	codes map[string]AuthorizationCode
+}
+
+func newMemoryAuthorizationCodeStore() *memoryAuthorizationCodeStore {
+	return &memoryAuthorizationCodeStore{codes: make(map[string]AuthorizationCode)}
+}
+
+func (s *memoryAuthorizationCodeStore) Create(_ context.Context, code AuthorizationCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.codes[code.Hash] = code
+	return nil
+}
+
+func (s *memoryAuthorizationCodeStore) Use(_ context.Context, hash, clientID string) (AuthorizationCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, ok := s.codes[hash]
+	if !ok || code.ClientID != clientID || code.Used {
+		return AuthorizationCode{}, errInvalidGrant
+	}
+	result := code
+	code.Used = true
+	s.codes[hash] = code
+	return result, nil
+}
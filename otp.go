@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	otpLength      = 6
+	otpTTL         = 5 * time.Minute
+	otpResendDelay = time.Minute
+	otpMaxAttempts = 5
+
+	otpPurposeLogin = "login"
+)
+
+var (
+	errOTPInvalid         = errors.New("invalid code")
+	errOTPTooManyAttempts = errors.New("too many attempts")
+	errOTPTooSoon         = errors.New("code requested too soon")
+)
+
+// generateNumericCode генерирует случайный числовой код заданной длины.
+func generateNumericCode(length int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(length)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", length, n), nil
+}
+
+// createOTP создает одноразовый код для телефона и назначения, заменяя предыдущий, и возвращает его.
+// Новый код можно запросить не чаще, чем раз в otpResendDelay.
+func (h *Handler) createOTP(ctx context.Context, phone, purpose string, ttl time.Duration) (string, error) {
+	existing, err := h.otpCodes.Find(ctx, phone, purpose)
+	if err == nil && time.Since(existing.CreatedAt) < otpResendDelay {
+		return "", errOTPTooSoon
+	}
+	if err != nil && !errors.Is(err, errOTPNotFound) {
+		return "", err
+	}
+
+	code, err := generateNumericCode(otpLength)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = h.otpCodes.Save(ctx, OTPCode{
+		Phone:     phone,
+		Purpose:   purpose,
+		CodeHash:  hashToken(code),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// issueOTP создает одноразовый код и отправляет его по SMS.
+func (h *Handler) issueOTP(ctx context.Context, phone, purpose string) error {
+	code, err := h.createOTP(ctx, phone, purpose, otpTTL)
+	if err != nil {
+		return err
+	}
+	return smsSender.Send(ctx, phone, fmt.Sprintf("Your code is %s. It expires in %d minutes.", code, int(otpTTL.Minutes())))
+}
+
+// verifyOTP проверяет одноразовый код. Успешно проверенный код удаляется,
+// а после otpMaxAttempts неудачных попыток код перестает приниматься.
+func (h *Handler) verifyOTP(ctx context.Context, phone, purpose, code string) error {
+	stored, err := h.otpCodes.Find(ctx, phone, purpose)
+	if errors.Is(err, errOTPNotFound) {
+		return errOTPInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return errOTPInvalid
+	}
+	if stored.Attempts >= otpMaxAttempts {
+		return errOTPTooManyAttempts
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(code)), []byte(stored.CodeHash)) != 1 {
+		if err := h.otpCodes.IncAttempts(ctx, phone, purpose); err != nil {
+			return err
+		}
+		return errOTPInvalid
+	}
+
+	// Delete the code so it cannot be used twice
+	deleted, err := h.otpCodes.Delete(ctx, phone, purpose, stored.CodeHash)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errOTPInvalid
+	}
+	return nil
+}
+
+// respondOTPError переводит ошибку проверки кода в HTTP-ответ.
+func respondOTPError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errOTPInvalid):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+	case errors.Is(err, errOTPTooManyAttempts):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, request a new code"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process code"})
+	}
+}
+
+// RequestOTP godoc
+//
+//	@Summary		Request a login code
+//	@Description	Send a one-time login code by SMS. The response does not reveal whether the phone is registered.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		OTPRequest			true	"Phone number"
+//	@Success		200		{object}	map[string]string	"Code sent"
+//	@Failure		400		{object}	map[string]string	"Invalid input or phone number"
+//	@Failure		429		{object}	map[string]string	"Too many requests"
+//	@Failure		500		{object}	map[string]string	"Failed to send code"
+//	@Router			/otp/request [post]
+func (h *Handler) RequestOTP(c *gin.Context) {
+	var req OTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	phone, err := normalizePhone(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+		return
+	}
+
+	user, err := h.users.FindByPhone(context.Background(), phone)
+	if err == nil && !user.Disabled {
+		// A code sent moments ago is still valid; answering 429 here would reveal the phone is registered
+		if err := h.issueOTP(context.Background(), user.Phone, otpPurposeLogin); err != nil && !errors.Is(err, errOTPTooSoon) {
+			respondOTPError(c, err)
+			return
+		}
+	} else if err != nil && !errors.Is(err, errUserNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the phone is registered, a code has been sent"})
+}
+
+// VerifyOTP godoc
+//
+//	@Summary		Log in with a code
+//	@Description	Exchange a one-time code sent by SMS for the same response Login returns
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			request			body		OTPVerifyRequest		true	"Phone number and code"
+//	@Param			X-Device-Name	header		string					false	"Device name shown in the session list"
+//	@Success		200				{object}	map[string]interface{}	"Access and refresh tokens"
+//	@Failure		400				{object}	map[string]string		"Invalid input or phone number"
+//	@Failure		401				{object}	map[string]string		"Invalid or expired code"
+//	@Failure		403				{object}	map[string]string		"Account is disabled"
+//	@Failure		429				{object}	map[string]string		"Too many attempts"
+//	@Router			/otp/verify [post]
+func (h *Handler) VerifyOTP(c *gin.Context) {
+	var req OTPVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	phone, err := normalizePhone(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+		return
+	}
+
+	if err := h.verifyOTP(context.Background(), phone, otpPurposeLogin, req.Code); err != nil {
+		respondOTPError(c, err)
+		return
+	}
+
+	// Receiving the code proves the user owns the phone number
+	user, err := h.users.FindByPhone(context.Background(), phone)
+	if err == nil {
+		verified := true
+		user, err = h.users.Update(context.Background(), user.ID, UserUpdate{PhoneVerified: &verified})
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired code"})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	h.completeLogin(c, user)
+}
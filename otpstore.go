@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errOTPNotFound = errors.New("otp not found")
+
+// OTPStore хранит одноразовые коды. Для пары телефон и назначение хранится не больше одного кода.
+type OTPStore interface {
+	// Find возвращает текущий код или errOTPNotFound.
+	Find(ctx context.Context, phone, purpose string) (OTPCode, error)
+	// Save сохраняет код, заменяя предыдущий код с тем же телефоном и назначением.
+	Save(ctx context.Context, code OTPCode) error
+	IncAttempts(ctx context.Context, phone, purpose string) error
+	// Delete удаляет код, только если его хеш равен codeHash, и сообщает, был ли код удален.
+	Delete(ctx context.Context, phone, purpose, codeHash string) (bool, error)
+}
+
+// LoginAttemptStore хранит счетчики неудачных попыток входа по ключам.
+type LoginAttemptStore interface {
+	// Find возвращает счетчики для существующих ключей из keys.
+	Find(ctx context.Context, keys ...string) ([]LoginAttempt, error)
+	// RecordFailure увеличивает счетчик ключа и возвращает его новое состояние.
+	// Неудачи, последняя из которых случилась раньше since, не учитываются.
+	RecordFailure(ctx context.Context, key string, since, at, expiresAt time.Time) (LoginAttempt, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+}
+
+// mongoOTPStore хранит коды в коллекции MongoDB. Просроченные коды удаляет TTL-индекс.
+type mongoOTPStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoOTPStore) Find(ctx context.Context, phone, purpose string) (OTPCode, error) {
+	var code OTPCode
+	err := s.collection.FindOne(ctx, bson.M{"phone": phone, "purpose": purpose}).Decode(&code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return OTPCode{}, errOTPNotFound
+	}
+	return code, err
+}
+
+func (s *mongoOTPStore) Save(ctx context.Context, code OTPCode) error {
+	_, err := s.collection.ReplaceOne(ctx,
+		bson.M{"phone": code.Phone, "purpose": code.Purpose},
+		code,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *mongoOTPStore) IncAttempts(ctx context.Context, phone, purpose string) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"phone": phone, "purpose": purpose},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+	)
+	return err
+}
+
+func (s *mongoOTPStore) Delete(ctx context.Context, phone, purpose, codeHash string) (bool, error) {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"phone": phone, "purpose": purpose, "code_hash": codeHash})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// mongoLoginAttemptStore хранит счетчики в коллекции MongoDB. Устаревшие счетчики удаляет TTL-индекс.
+type mongoLoginAttemptStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoLoginAttemptStore) Find(ctx context.Context, keys ...string) ([]LoginAttempt, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{"key": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var attempts []LoginAttempt
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func (s *mongoLoginAttemptStore) RecordFailure(ctx context.Context, key string, since, at, expiresAt time.Time) (LoginAttempt, error) {
+	// Start counting from scratch once the previous failures fall out of the window
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"key": key, "last_failure": bson.M{"$lt": since}},
+		bson.M{"$set": bson.M{"failures": 0}},
+	)
+	if err != nil {
+		return LoginAttempt{}, err
+	}
+
+	var attempt LoginAttempt
+	err = s.collection.FindOneAndUpdate(ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"last_failure": at, "expires_at": expiresAt},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	return attempt, err
+}
+
+func (s *mongoLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"key": key},
+		bson.M{"$set": bson.M{"locked_until": until}},
+	)
+	return err
+}
+
+func (s *mongoLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.M{"key": key})
+	return err
+}
+
+// otpKey задает ключ кода в хранилищах без составных индексов.
+func otpKey(phone, purpose string) string { return purpose + ":" + phone }
+
+// memoryOTPStore хранит коды в памяти процесса.
+type memoryOTPStore struct {
+	mu    sync.Mutex
+	codes map[string]OTPCode
+}
+
+func newMemoryOTPStore() *memoryOTPStore {
+	return &memoryOTPStore{codes: make(map[string]OTPCode)}
+}
+
+func (s *memoryOTPStore) Find(_ context.Context, phone, purpose string) (OTPCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code, ok := s.codes[otpKey(phone, purpose)]
+	if !ok {
+		return OTPCode{}, errOTPNotFound
+	}
+	return code, nil
+}
+
+func (s *memoryOTPStore) Save(_ context.Context, code OTPCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.codes[otpKey(code.Phone, code.Purpose)] = code
+	return nil
+}
+
+func (s *memoryOTPStore) IncAttempts(_ context.Context, phone, purpose string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := otpKey(phone, purpose)
+	if code, ok := s.codes[key]; ok {
+		code.Attempts++
+		s.codes[key] = code
+	}
+	return nil
+}
+
+func (s *memoryOTPStore) Delete(_ context.Context, phone, purpose, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := otpKey(phone, purpose)
+	code, ok := s.codes[key]
+	if !ok || code.CodeHash != codeHash {
+		return false, nil
+	}
+	delete(s.codes, key)
+	return true, nil
+}
+
+// memoryLoginAttemptStore хранит счетчики в памяти процесса.
+type memoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempt
+}
+
+func newMemoryLoginAttemptStore() *memoryLoginAttemptStore {
+	return &memoryLoginAttemptStore{attempts: make(map[string]LoginAttempt)}
+}
+
+func (s *memoryLoginAttemptStore) Find(_ context.Context, keys ...string) ([]LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var attempts []LoginAttempt
+	for _, key := range keys {
+		if attempt, ok := s.attempts[key]; ok {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+func (s *memoryLoginAttemptStore) RecordFailure(_ context.Context, key string, since, at, expiresAt time.Time) (LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		attempt = LoginAttempt{Key: key}
+	}
+	if attempt.LastFailure.Before(since) {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailure = at
+	attempt.ExpiresAt = expiresAt
+	s.attempts[key] = attempt
+	return attempt, nil
+}
+
+func (s *memoryLoginAttemptStore) Lock(_ context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok {
+		attempt.LockedUntil = until
+		s.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (s *memoryLoginAttemptStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	passwordClassUpper  = "upper"
+	passwordClassLower  = "lower"
+	passwordClassDigit  = "digit"
+	passwordClassSymbol = "symbol"
+)
+
+// passwordClassNames используется в сообщениях о нарушениях политики.
+var passwordClassNames = map[string]string{
+	passwordClassUpper:  "uppercase letter",
+	passwordClassLower:  "lowercase letter",
+	passwordClassDigit:  "digit",
+	passwordClassSymbol: "symbol",
+}
+
+// PasswordPolicy описывает требования к паролям пользователей.
+type PasswordPolicy struct {
+	MinLength       int
+	MaxBytes        int
+	RequiredClasses []string
+	ForbidPhone     bool
+	CheckBreached   bool
+}
+
+// PasswordViolation описывает одно нарушенное правило политики паролей.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// passwordPolicy действует по умолчанию; bcrypt игнорирует все после первых 72 байт пароля.
+var passwordPolicy = PasswordPolicy{
+	MinLength:       8,
+	MaxBytes:        72,
+	RequiredClasses: []string{passwordClassUpper, passwordClassLower, passwordClassDigit},
+	ForbidPhone:     true,
+}
+
+// breachedPasswords хранит суффиксы SHA-1 утекших паролей, сгруппированные по 5-символьному префиксу,
+// как в k-anonymity API Have I Been Pwned.
+var breachedPasswords map[string]map[string]struct{}
+
+// initPasswordPolicy читает настройки политики паролей из окружения:
+// PASSWORD_MIN_LENGTH, PASSWORD_REQUIRED_CLASSES (через запятую: upper, lower, digit, symbol; "none" - без требований)
+// и PASSWORD_BREACHED_LIST - путь к списку SHA-1 хешей утекших паролей.
+func initPasswordPolicy() {
+	if n := config.PasswordMinLength; n < 1 || n > passwordPolicy.MaxBytes {
+		log.Fatalf("invalid PASSWORD_MIN_LENGTH %d", n)
+	}
+	passwordPolicy.MinLength = config.PasswordMinLength
+
+	if value := config.PasswordRequiredClasses; value != "" {
+		classes := []string{}
+		if value != "none" {
+			for _, class := range strings.Split(value, ",") {
+				class = strings.TrimSpace(class)
+				switch class {
+				case passwordClassUpper, passwordClassLower, passwordClassDigit, passwordClassSymbol:
+					classes = append(classes, class)
+				default:
+					log.Fatalf("unknown password class %q in PASSWORD_REQUIRED_CLASSES", class)
+				}
+			}
+		}
+		passwordPolicy.RequiredClasses = classes
+	}
+
+	if path := config.PasswordBreachedList; path != "" {
+		list, err := loadBreachedPasswords(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		breachedPasswords = list
+		passwordPolicy.CheckBreached = true
+		log.Printf("Loaded breached password list with %d prefixes", len(list))
+	}
+}
+
+// loadBreachedPasswords загружает файл со строками вида "SHA1[:COUNT]" (формат выгрузки Have I Been Pwned).
+func loadBreachedPasswords(path string) (map[string]map[string]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := make(map[string]map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" {
+			continue
+		}
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-1 hash", path, line)
+		}
+		hash = strings.ToUpper(hash)
+		prefix, suffix := hash[:5], hash[5:]
+		if list[prefix] == nil {
+			list[prefix] = make(map[string]struct{})
+		}
+		list[prefix][suffix] = struct{}{}
+	}
+	return list, scanner.Err()
+}
+
+// isBreachedPassword проверяет пароль по загруженному списку, обращаясь только к диапазону его префикса.
+func isBreachedPassword(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, found := breachedPasswords[hash[:5]][hash[5:]]
+	return found
+}
+
+// hasPasswordClass проверяет наличие в пароле хотя бы одного символа указанного класса.
+func hasPasswordClass(password, class string) bool {
+	for _, r := range password {
+		switch {
+		case class == passwordClassUpper && unicode.IsUpper(r),
+			class == passwordClassLower && unicode.IsLower(r),
+			class == passwordClassDigit && unicode.IsDigit(r),
+			class == passwordClassSymbol && (unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r)):
+			return true
+		}
+	}
+	return false
+}
+
+// Check возвращает все правила политики, которые нарушает пароль. Пустой результат означает, что пароль подходит.
+func (p PasswordPolicy) Check(password, phone string) []PasswordViolation {
+	violations := []PasswordViolation{}
+	add := func(rule, message string) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: message})
+	}
+
+	if len([]rune(password)) < p.MinLength {
+		add("min_length", fmt.Sprintf("Password must be at least %d characters long", p.MinLength))
+	}
+	if len(password) > p.MaxBytes {
+		add("max_length", fmt.Sprintf("Password must be at most %d bytes long", p.MaxBytes))
+	}
+	for _, class := range p.RequiredClasses {
+		if !hasPasswordClass(password, class) {
+			add("class_"+class, "Password must contain at least one "+passwordClassNames[class])
+		}
+	}
+	if p.ForbidPhone && phone != "" {
+		digits := strings.TrimPrefix(phone, "+")
+		// Also catch the national number typed without the country code
+		if strings.Contains(password, digits) || (len(digits) > 10 && strings.Contains(password, digits[len(digits)-10:])) {
+			add("no_phone", "Password must not contain the phone number")
+		}
+	}
+	if p.CheckBreached && isBreachedPassword(password) {
+		add("breached", "Password has appeared in a data breach, choose another one")
+	}
+	return violations
+}
+
+// respondPasswordViolations отвечает списком всех нарушенных правил политики паролей.
+func respondPasswordViolations(c *gin.Context, violations []PasswordViolation) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":      "Password does not meet the password policy",
+		"violations": violations,
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	passwordResetTTL = 15 * time.Minute
+
+	otpPurposePasswordReset = "password_reset"
+)
+
+// passwordResetMessage формирует текст уведомления со ссылкой PASSWORD_RESET_URL, если она задана, или только с кодом.
+func passwordResetMessage(phone, code string) string {
+	base := config.PasswordResetURL
+	if base == "" {
+		return fmt.Sprintf("Your password reset code is %s. It expires in %d minutes.", code, int(passwordResetTTL.Minutes()))
+	}
+
+	link, err := url.Parse(base)
+	if err != nil {
+		log.Println("Invalid PASSWORD_RESET_URL:", err)
+		return fmt.Sprintf("Your password reset code is %s. It expires in %d minutes.", code, int(passwordResetTTL.Minutes()))
+	}
+	query := link.Query()
+	query.Set("phone", phone)
+	query.Set("code", code)
+	link.RawQuery = query.Encode()
+	return fmt.Sprintf("Reset your password: %s (expires in %d minutes)", link.String(), int(passwordResetTTL.Minutes()))
+}
+
+// ForgotPassword godoc
+//
+//	@Summary		Request a password reset
+//	@Description	Send a single-use, time-limited password reset code. The response does not reveal whether the phone is registered.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		OTPRequest			true	"Phone number"
+//	@Success		200		{object}	map[string]string	"Reset code sent"
+//	@Failure		400		{object}	map[string]string	"Invalid input or phone number"
+//	@Failure		429		{object}	map[string]string	"Too many requests"
+//	@Failure		500		{object}	map[string]string	"Failed to send code"
+//	@Router			/password/forgot [post]
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req OTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	phone, err := normalizePhone(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+		return
+	}
+
+	user, err := h.users.FindByPhone(context.Background(), phone)
+	if errors.Is(err, errUserNotFound) || (err == nil && user.Disabled) {
+		c.JSON(http.StatusOK, gin.H{"message": "If the phone is registered, a reset code has been sent"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+
+	code, err := h.createOTP(context.Background(), phone, otpPurposePasswordReset, passwordResetTTL)
+	if errors.Is(err, errOTPTooSoon) {
+		// A code sent moments ago is still valid; answering 429 here would reveal the phone is registered
+		c.JSON(http.StatusOK, gin.H{"message": "If the phone is registered, a reset code has been sent"})
+		return
+	}
+	if err != nil {
+		respondOTPError(c, err)
+		return
+	}
+	if err := notifier.Notify(context.Background(), user, "Password reset", passwordResetMessage(phone, code)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the phone is registered, a reset code has been sent"})
+}
+
+// ResetPassword godoc
+//
+//	@Summary		Reset a forgotten password
+//	@Description	Set a new password using the reset code. All existing sessions of the user are terminated.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		ResetPasswordRequest	true	"Phone number, reset code and new password"
+//	@Success		200		{object}	map[string]string		"Password reset successfully"
+//	@Failure		400		{object}	map[string]interface{}	"Invalid input, phone number or password policy violations"
+//	@Failure		401		{object}	map[string]string		"Invalid or expired code"
+//	@Failure		429		{object}	map[string]string		"Too many attempts"
+//	@Failure		500		{object}	map[string]string		"Failed to reset password"
+//	@Router			/password/reset [post]
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	phone, err := normalizePhone(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+		return
+	}
+
+	if violations := passwordPolicy.Check(req.NewPassword, phone); len(violations) > 0 {
+		respondPasswordViolations(c, violations)
+		return
+	}
+
+	if err := h.verifyOTP(context.Background(), phone, otpPurposePasswordReset, req.Code); err != nil {
+		respondOTPError(c, err)
+		return
+	}
+
+	hashedPassword, err := HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	user, err := h.users.FindByPhone(context.Background(), phone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	// Receiving the code proves the user owns the phone number
+	verified, mustChange := true, false
+	user, err = h.users.Update(context.Background(), user.ID,
+		UserUpdate{Password: &hashedPassword, PhoneVerified: &verified, MustChangePassword: &mustChange})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	if err := h.revokeAllSessions(context.Background(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
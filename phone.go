@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	otpPurposeVerifyPhone = "verify_phone"
+
+	unverifiedLoginAllow = "allow"
+	unverifiedLoginDeny  = "deny"
+)
+
+var errInvalidPhone = errors.New("invalid phone number")
+
+// phoneRegion описывает код страны и национальный префикс выхода на междугороднюю связь.
+type phoneRegion struct {
+	countryCode string
+	trunkPrefix string
+}
+
+// phoneRegions перечисляет регионы, которые можно выбрать регионом по умолчанию.
+var phoneRegions = map[string]phoneRegion{
+	"KZ": {countryCode: "7", trunkPrefix: "8"},
+	"RU": {countryCode: "7", trunkPrefix: "8"},
+	"KG": {countryCode: "996", trunkPrefix: "0"},
+	"UZ": {countryCode: "998", trunkPrefix: ""},
+	"US": {countryCode: "1", trunkPrefix: "1"},
+	"GB": {countryCode: "44", trunkPrefix: "0"},
+	"DE": {countryCode: "49", trunkPrefix: "0"},
+	"TR": {countryCode: "90", trunkPrefix: "0"},
+}
+
+var (
+	defaultPhoneRegion    = phoneRegions["KZ"]
+	unverifiedLoginPolicy = unverifiedLoginAllow
+)
+
+// initPhone читает регион по умолчанию PHONE_DEFAULT_REGION и политику входа
+// для неподтвержденных номеров UNVERIFIED_LOGIN_POLICY ("allow" или "deny").
+func initPhone() {
+	if code := config.PhoneDefaultRegion; code != "" {
+		region, ok := phoneRegions[strings.ToUpper(code)]
+		if !ok {
+			log.Fatalf("unknown PHONE_DEFAULT_REGION %q", code)
+		}
+		defaultPhoneRegion = region
+	}
+
+	switch policy := config.UnverifiedLoginPolicy; policy {
+	case "":
+	case unverifiedLoginAllow, unverifiedLoginDeny:
+		unverifiedLoginPolicy = policy
+	default:
+		log.Fatalf("unknown UNVERIFIED_LOGIN_POLICY %q", policy)
+	}
+}
+
+// normalizePhone приводит номер телефона к формату E.164.
+// Номера без международного префикса считаются номерами региона по умолчанию.
+func normalizePhone(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+
+	var digits strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.' || (r == '+' && digits.Len() == 0):
+		default:
+			return "", errInvalidPhone
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case international:
+	case strings.HasPrefix(number, "00"):
+		number = strings.TrimPrefix(number, "00")
+	case defaultPhoneRegion.trunkPrefix != "" && strings.HasPrefix(number, defaultPhoneRegion.trunkPrefix):
+		number = defaultPhoneRegion.countryCode + strings.TrimPrefix(number, defaultPhoneRegion.trunkPrefix)
+	case strings.HasPrefix(number, defaultPhoneRegion.countryCode) && len(number) > 10:
+	default:
+		number = defaultPhoneRegion.countryCode + number
+	}
+
+	// E.164 allows at most 15 digits and country codes never start with 0
+	if len(number) < 8 || len(number) > 15 || number[0] == '0' {
+		return "", errInvalidPhone
+	}
+	return "+" + number, nil
+}
+
+// RequestPhoneVerification godoc
+//
+//	@Summary		Resend the phone confirmation code
+//	@Description	Send a new confirmation code to an unverified phone number
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		OTPRequest			true	"Phone number"
+//	@Success		200		{object}	map[string]string	"Code sent"
+//	@Failure		400		{object}	map[string]string	"Invalid input"
+//	@Failure		429		{object}	map[string]string	"Too many requests"
+//	@Failure		500		{object}	map[string]string	"Failed to send code"
+//	@Router			/phone/verify/request [post]
+func (h *Handler) RequestPhoneVerification(c *gin.Context) {
+	var req OTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	phone, err := normalizePhone(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+		return
+	}
+
+	user, err := h.users.FindByPhone(context.Background(), phone)
+	if err == nil && !user.PhoneVerified {
+		// A code sent moments ago is still valid; answering 429 here would reveal the phone is unverified
+		if err := h.issueOTP(context.Background(), phone, otpPurposeVerifyPhone); err != nil && !errors.Is(err, errOTPTooSoon) {
+			respondOTPError(c, err)
+			return
+		}
+	} else if err != nil && !errors.Is(err, errUserNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the phone is registered and unverified, a code has been sent"})
+}
+
+// VerifyPhone godoc
+//
+//	@Summary		Confirm a phone number
+//	@Description	Confirm the phone number of a newly registered user with the code sent by SMS
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		OTPVerifyRequest	true	"Phone number and code"
+//	@Success		200		{object}	map[string]string	"Phone verified successfully"
+//	@Failure		400		{object}	map[string]string	"Invalid input"
+//	@Failure		401		{object}	map[string]string	"Invalid or expired code"
+//	@Failure		429		{object}	map[string]string	"Too many attempts"
+//	@Failure		500		{object}	map[string]string	"Failed to verify phone"
+//	@Router			/phone/verify [post]
+func (h *Handler) VerifyPhone(c *gin.Context) {
+	var req OTPVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	phone, err := normalizePhone(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid phone number"})
+		return
+	}
+
+	if err := h.verifyOTP(context.Background(), phone, otpPurposeVerifyPhone, req.Code); err != nil {
+		respondOTPError(c, err)
+		return
+	}
+
+	user, err := h.users.FindByPhone(context.Background(), phone)
+	if err == nil {
+		verified := true
+		_, err = h.users.Update(context.Background(), user.ID, UserUpdate{PhoneVerified: &verified})
+	}
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify phone"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Phone verified successfully"})
+}
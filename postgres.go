@@ -0,0 +1,586 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrationFiles embed.FS
+
+// postgresUniqueViolation - код ошибки PostgreSQL при нарушении уникального ограничения.
+const postgresUniqueViolation = "23505"
+
+// postgresMigration - версия схемы с SQL для наката и отката.
+type postgresMigration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// loadPostgresMigrations читает встроенные файлы вида <version>_<name>.up.sql и .down.sql.
+func loadPostgresMigrations() ([]postgresMigration, error) {
+	entries, err := fs.ReadDir(postgresMigrationFiles, "migrations/postgres")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*postgresMigration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction := strings.TrimSuffix(name, ".sql"), ""
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			base, direction = strings.TrimSuffix(base, ".up"), "up"
+		case strings.HasSuffix(base, ".down"):
+			base, direction = strings.TrimSuffix(base, ".down"), "down"
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql", name)
+		}
+		versionPart, title, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(versionPart)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version", name)
+		}
+
+		content, err := fs.ReadFile(postgresMigrationFiles, path.Join("migrations/postgres", name))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &postgresMigration{Version: version, Name: title}
+			byVersion[version] = migration
+		}
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]postgresMigration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// postgresAppliedMigrations создает таблицу schema_migrations, если ее нет,
+// и возвращает время применения миграций по версиям.
+func postgresAppliedMigrations(ctx context.Context, db *sql.DB) (map[int]time.Time, error) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runPostgresMigration выполняет SQL миграции и запись в schema_migrations в одной транзакции.
+func runPostgresMigration(ctx context.Context, db *sql.DB, migration postgresMigration, query, record string, args ...interface{}) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migratePostgres применяет еще не примененные миграции, каждую в своей транзакции.
+// Примененные версии записываются в таблицу schema_migrations.
+func migratePostgres(ctx context.Context, db *sql.DB) error {
+	applied, err := postgresAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	migrations, err := loadPostgresMigrations()
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := runPostgresMigration(ctx, db, migration, migration.Up,
+			`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name,
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied PostgreSQL migration %d_%s", migration.Version, migration.Name)
+	}
+	return nil
+}
+
+// rollbackPostgres откатывает последнюю примененную миграцию.
+func rollbackPostgres(ctx context.Context, db *sql.DB) error {
+	applied, err := postgresAppliedMigrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	migrations, err := loadPostgresMigrations()
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err := runPostgresMigration(ctx, db, migration, migration.Down,
+			`DELETE FROM schema_migrations WHERE version = $1`, migration.Version,
+		)
+		if err != nil {
+			return err
+		}
+		log.Printf("Reverted PostgreSQL migration %d_%s", migration.Version, migration.Name)
+		return nil
+	}
+	log.Println("No PostgreSQL migrations to revert")
+	return nil
+}
+
+// postgresMigrationStatus возвращает все миграции PostgreSQL с временем применения.
+func postgresMigrationStatus(ctx context.Context, db *sql.DB) ([]migrationState, error) {
+	applied, err := postgresAppliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := loadPostgresMigrations()
+	if err != nil {
+		return nil, err
+	}
+	states := make([]migrationState, 0, len(migrations))
+	for _, migration := range migrations {
+		states = append(states, migrationState{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: applied[migration.Version],
+		})
+	}
+	return states, nil
+}
+
+// openPostgres подключается к PostgreSQL по POSTGRES_DSN.
+func openPostgres() *sql.DB {
+	db, err := sql.Open("postgres", config.PostgresDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := db.PingContext(context.TODO()); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Connected to PostgreSQL!")
+	return db
+}
+
+// initPostgres подключается к PostgreSQL и применяет миграции, если задан AUTO_MIGRATE,
+// иначе проверяет, что все миграции уже применены.
+func initPostgres() *sql.DB {
+	db := openPostgres()
+	var err error
+	if config.AutoMigrate {
+		err = migratePostgres(context.TODO(), db)
+	} else {
+		err = pendingMigrations(postgresMigrationStatus(context.TODO(), db))
+	}
+	if err != nil {
+		log.Fatalf("PostgreSQL: %v", err)
+	}
+	return db
+}
+
+// isUniqueViolation сообщает, что запрос нарушил уникальное ограничение.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == postgresUniqueViolation
+}
+
+// objectIDValue хранит ObjectID как hex-строку, чтобы в JSON идентификаторы не отличались от MongoDB.
+type objectIDValue struct {
+	id *primitive.ObjectID
+}
+
+func (v objectIDValue) Scan(src interface{}) error {
+	var hex string
+	switch src := src.(type) {
+	case nil:
+		*v.id = primitive.NilObjectID
+		return nil
+	case string:
+		hex = src
+	case []byte:
+		hex = string(src)
+	default:
+		return fmt.Errorf("cannot scan %T into ObjectID", src)
+	}
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(hex))
+	if err != nil {
+		return err
+	}
+	*v.id = id
+	return nil
+}
+
+// nullObjectID сохраняет нулевой ObjectID как NULL.
+func nullObjectID(id primitive.ObjectID) interface{} {
+	if id.IsZero() {
+		return nil
+	}
+	return id.Hex()
+}
+
+// timeValue читает NULL как нулевое время, как его возвращает MongoDB для отсутствующего поля.
+type timeValue struct {
+	t *time.Time
+}
+
+func (v timeValue) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*v.t = time.Time{}
+	case time.Time:
+		*v.t = src
+	default:
+		return fmt.Errorf("cannot scan %T into time.Time", src)
+	}
+	return nil
+}
+
+// nullTime сохраняет нулевое время как NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+const postgresUserColumns = `id, name, phone, phone_verified, password, role, disabled, token_version,
+	totp_enabled, totp_secret, totp_pending_url, totp_last_step, recovery_codes, must_change_password`
+
+// postgresUserStore хранит пользователей в таблице users.
+type postgresUserStore struct {
+	db *sql.DB
+}
+
+// rowScanner - общий интерфейс *sql.Row и *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPostgresUser(row rowScanner) (User, error) {
+	var user User
+	err := row.Scan(
+		objectIDValue{&user.ID}, &user.Name, &user.Phone, &user.PhoneVerified, &user.Password, &user.Role,
+		&user.Disabled, &user.TokenVersion, &user.TOTPEnabled, &user.TOTPSecret, &user.TOTPPendingURL,
+		&user.TOTPLastStep, pq.Array(&user.RecoveryCodes), &user.MustChangePassword,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, errUserNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if len(user.RecoveryCodes) == 0 {
+		user.RecoveryCodes = nil
+	}
+	return user, nil
+}
+
+func (s *postgresUserStore) Create(ctx context.Context, user User) error {
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (`+postgresUserColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		user.ID.Hex(), user.Name, user.Phone, user.PhoneVerified, user.Password, user.Role,
+		user.Disabled, user.TokenVersion, user.TOTPEnabled, user.TOTPSecret, user.TOTPPendingURL,
+		user.TOTPLastStep, pq.Array(nonNilStrings(user.RecoveryCodes)), user.MustChangePassword,
+	)
+	if isUniqueViolation(err) {
+		return errPhoneTaken
+	}
+	return err
+}
+
+// nonNilStrings заменяет nil пустым срезом, чтобы в NOT NULL колонку попал пустой массив.
+func nonNilStrings(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func (s *postgresUserStore) FindByID(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return scanPostgresUser(s.db.QueryRowContext(ctx,
+		`SELECT `+postgresUserColumns+` FROM users WHERE id = $1`, id.Hex(),
+	))
+}
+
+func (s *postgresUserStore) FindByPhone(ctx context.Context, phone string) (User, error) {
+	return scanPostgresUser(s.db.QueryRowContext(ctx,
+		`SELECT `+postgresUserColumns+` FROM users WHERE phone = $1`, phone,
+	))
+}
+
+func (s *postgresUserStore) List(ctx context.Context, search string, skip, limit int64) ([]User, int64, error) {
+	// strpos avoids escaping LIKE wildcards in the search string
+	const where = `WHERE $1 = '' OR strpos(lower(phone), lower($1)) > 0 OR strpos(lower(name), lower($1)) > 0`
+
+	var total int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users `+where, search).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	// A zero limit means no limit, same as the other stores
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+postgresUserColumns+` FROM users `+where+` ORDER BY id OFFSET $2 LIMIT NULLIF($3, 0)`,
+		search, skip, limit,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanPostgresUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, rows.Err()
+}
+
+func (s *postgresUserStore) Update(ctx context.Context, id primitive.ObjectID, u UserUpdate) (User, error) {
+	var (
+		set  []string
+		args = []interface{}{id.Hex()}
+	)
+	add := func(column string, value interface{}) {
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	if u.Name != nil {
+		add("name", *u.Name)
+	}
+	if u.Phone != nil {
+		add("phone", *u.Phone)
+	}
+	if u.PhoneVerified != nil {
+		add("phone_verified", *u.PhoneVerified)
+	}
+	if u.Password != nil {
+		add("password", *u.Password)
+	}
+	if u.Role != nil {
+		add("role", *u.Role)
+	}
+	if u.Disabled != nil {
+		add("disabled", *u.Disabled)
+	}
+	if u.MustChangePassword != nil {
+		add("must_change_password", *u.MustChangePassword)
+	}
+	if u.TOTPEnabled != nil {
+		add("totp_enabled", *u.TOTPEnabled)
+	}
+	if u.TOTPSecret != nil {
+		add("totp_secret", *u.TOTPSecret)
+	}
+	if u.TOTPPendingURL != nil {
+		add("totp_pending_url", *u.TOTPPendingURL)
+	}
+	if u.TOTPLastStep != nil {
+		add("totp_last_step", *u.TOTPLastStep)
+	}
+	if u.RecoveryCodes != nil {
+		add("recovery_codes", pq.Array(nonNilStrings(*u.RecoveryCodes)))
+	}
+	if u.IncTokenVersion {
+		set = append(set, "token_version = token_version + 1")
+	}
+	if len(set) == 0 {
+		return s.FindByID(ctx, id)
+	}
+
+	user, err := scanPostgresUser(s.db.QueryRowContext(ctx,
+		`UPDATE users SET `+strings.Join(set, ", ")+` WHERE id = $1 RETURNING `+postgresUserColumns,
+		args...,
+	))
+	if isUniqueViolation(err) {
+		return User{}, errPhoneTaken
+	}
+	return user, err
+}
+
+// updateIf выполняет обновление и сообщает, затронуло ли оно пользователя.
+func (s *postgresUserStore) updateIf(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (s *postgresUserStore) ReplacePassword(ctx context.Context, id primitive.ObjectID, current, hash string) (bool, error) {
+	return s.updateIf(ctx,
+		`UPDATE users SET password = $3 WHERE id = $1 AND password = $2`, id.Hex(), current, hash,
+	)
+}
+
+func (s *postgresUserStore) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	return s.updateIf(ctx,
+		`UPDATE users SET totp_last_step = $2 WHERE id = $1 AND totp_last_step < $2`, id.Hex(), step,
+	)
+}
+
+func (s *postgresUserStore) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	return s.updateIf(ctx,
+		`UPDATE users SET recovery_codes = array_remove(recovery_codes, $2) WHERE id = $1 AND $2 = ANY (recovery_codes)`,
+		id.Hex(), hash,
+	)
+}
+
+func (s *postgresUserStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id.Hex())
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+const postgresPostColumns = `id, title, text, image, author, date, author_id`
+
+// postgresPostStore хранит посты в таблице posts.
+type postgresPostStore struct {
+	db *sql.DB
+}
+
+// postgresPostFilter добавляет к запросу проверку автора, если он указан.
+func postgresPostFilter(id, authorID primitive.ObjectID, args []interface{}) (string, []interface{}) {
+	args = append(args, id.Hex())
+	where := fmt.Sprintf("id = $%d", len(args))
+	if !authorID.IsZero() {
+		args = append(args, authorID.Hex())
+		where += fmt.Sprintf(" AND author_id = $%d", len(args))
+	}
+	return where, args
+}
+
+// affectedPost возвращает errPostNotFound, если запрос не затронул ни одного поста.
+func affectedPost(result sql.Result, err error) error {
+	affected, err := rowsAffected(result, err)
+	if err == nil && affected == 0 {
+		return errPostNotFound
+	}
+	return err
+}
+
+func (s *postgresPostStore) Create(ctx context.Context, post Post) error {
+	if post.ID.IsZero() {
+		post.ID = primitive.NewObjectID()
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO posts (`+postgresPostColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		post.ID.Hex(), post.Title, post.Text, post.Image, post.Author, post.Date, post.AuthorID.Hex(),
+	)
+	return err
+}
+
+func (s *postgresPostStore) List(ctx context.Context) ([]Post, error) {
+	// Hex ObjectIDs sort by creation time, same order as the MongoDB store
+	rows, err := s.db.QueryContext(ctx, `SELECT `+postgresPostColumns+` FROM posts ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		var post Post
+		err := rows.Scan(
+			objectIDValue{&post.ID}, &post.Title, &post.Text, &post.Image, &post.Author, &post.Date,
+			objectIDValue{&post.AuthorID},
+		)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func (s *postgresPostStore) Update(ctx context.Context, id, authorID primitive.ObjectID, post Post) error {
+	where, args := postgresPostFilter(id, authorID, []interface{}{post.Title, post.Text, post.Image, post.Date})
+	return affectedPost(s.db.ExecContext(ctx,
+		`UPDATE posts SET title = $1, text = $2, image = $3, date = $4 WHERE `+where, args...,
+	))
+}
+
+func (s *postgresPostStore) Delete(ctx context.Context, id, authorID primitive.ObjectID) error {
+	where, args := postgresPostFilter(id, authorID, nil)
+	return affectedPost(s.db.ExecContext(ctx, `DELETE FROM posts WHERE `+where, args...))
+}
+
+func (s *postgresPostStore) DeleteByAuthor(ctx context.Context, authorID primitive.ObjectID) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM posts WHERE author_id = $1`, authorID.Hex())
+	return err
+}
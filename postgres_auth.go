@@ -0,0 +1,602 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// postgresPurgeInterval - как часто удаляются просроченные записи.
+const postgresPurgeInterval = 10 * time.Minute
+
+// postgresExpiry - запросы, которые удаляют записи старше $1, и сколько запись живет после своего времени.
+// Заменяют TTL-индексы MongoDB.
+var postgresExpiry = []struct {
+	query string
+	after time.Duration
+}{
+	{`DELETE FROM refresh_tokens WHERE expires_at < $1`, 0},
+	{`DELETE FROM revoked_tokens WHERE expires_at < $1`, 0},
+	{`DELETE FROM otp_codes WHERE expires_at < $1`, 0},
+	{`DELETE FROM oauth_codes WHERE expires_at < $1`, 0},
+	{`DELETE FROM login_attempts WHERE expires_at < $1`, 0},
+	{`DELETE FROM rate_limits WHERE expires_at < $1`, 0},
+	{`DELETE FROM webauthn_ceremonies WHERE expires_at < $1`, 0},
+	// A session idle for longer than a refresh token lives can no longer be resumed
+	{`DELETE FROM sessions WHERE last_seen_at < $1`, refreshTokenTTL},
+}
+
+// purgeExpiredPostgres удаляет записи, срок которых истек к моменту now.
+func purgeExpiredPostgres(ctx context.Context, db *sql.DB, now time.Time) error {
+	for _, expiry := range postgresExpiry {
+		if _, err := db.ExecContext(ctx, expiry.query, now.Add(-expiry.after)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startPostgresPurge периодически удаляет просроченные записи в фоне.
+func startPostgresPurge(db *sql.DB) {
+	go func() {
+		for range time.Tick(postgresPurgeInterval) {
+			if err := purgeExpiredPostgres(context.Background(), db, time.Now()); err != nil {
+				log.Println("Error purging expired PostgreSQL records:", err)
+			}
+		}
+	}()
+}
+
+// newPostgresStores создает хранилища поверх таблиц PostgreSQL. Лимиты запросов хранятся в памяти.
+func newPostgresStores(db *sql.DB) Stores {
+	return Stores{
+		users:               &postgresUserStore{db: db},
+		posts:               &postgresPostStore{db: db},
+		sessions:            &postgresSessionStore{db: db},
+		refreshTokens:       &postgresRefreshTokenStore{db: db},
+		revokedTokens:       &postgresRevokedTokenStore{db: db},
+		otpCodes:            &postgresOTPStore{db: db},
+		loginAttempts:       &postgresLoginAttemptStore{db: db},
+		rateLimits:          newMemoryRateLimitStore(),
+		apiKeys:             &postgresAPIKeyStore{db: db},
+		webAuthnCredentials: &postgresWebAuthnCredentialStore{db: db},
+		webAuthnCeremonies:  &postgresWebAuthnCeremonyStore{db: db},
+		oauthClients:        &postgresOAuthClientStore{db: db},
+		oauthCodes:          &postgresAuthorizationCodeStore{db: db},
+	}
+}
+
+// rowsAffected возвращает число строк, затронутых запросом.
+func rowsAffected(result sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// execOnly отбрасывает результат запроса, которому не важно число затронутых строк.
+func execOnly(_ sql.Result, err error) error {
+	return err
+}
+
+const postgresSessionColumns = `id, phone, device, ip, user_agent, created_at, last_seen_at, revoked`
+
+// postgresSessionStore хранит сессии в таблице sessions.
+type postgresSessionStore struct {
+	db *sql.DB
+}
+
+func (s *postgresSessionStore) Create(ctx context.Context, session Session) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`INSERT INTO sessions (`+postgresSessionColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		session.ID.Hex(), session.Phone, session.Device, session.IP, session.UserAgent,
+		session.CreatedAt, session.LastSeenAt, session.Revoked,
+	))
+}
+
+func (s *postgresSessionStore) Touch(ctx context.Context, id primitive.ObjectID, phone, ip string, at time.Time) error {
+	affected, err := rowsAffected(s.db.ExecContext(ctx,
+		`UPDATE sessions SET last_seen_at = $4, ip = $3 WHERE id = $1 AND phone = $2 AND NOT revoked`,
+		id.Hex(), phone, ip, at,
+	))
+	if err == nil && affected == 0 {
+		return errSessionNotFound
+	}
+	return err
+}
+
+func (s *postgresSessionStore) Find(ctx context.Context, id primitive.ObjectID, phone string) (Session, error) {
+	var session Session
+	err := s.db.QueryRowContext(ctx,
+		`SELECT `+postgresSessionColumns+` FROM sessions WHERE id = $1 AND phone = $2 AND NOT revoked`, id.Hex(), phone,
+	).Scan(
+		objectIDValue{&session.ID}, &session.Phone, &session.Device, &session.IP, &session.UserAgent,
+		&session.CreatedAt, &session.LastSeenAt, &session.Revoked,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Session{}, errSessionNotFound
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func (s *postgresSessionStore) ListActive(ctx context.Context, phone string) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+postgresSessionColumns+` FROM sessions WHERE phone = $1 AND NOT revoked ORDER BY id`, phone,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			objectIDValue{&session.ID}, &session.Phone, &session.Device, &session.IP, &session.UserAgent,
+			&session.CreatedAt, &session.LastSeenAt, &session.Revoked,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *postgresSessionStore) Revoke(ctx context.Context, id primitive.ObjectID, phone string) error {
+	affected, err := rowsAffected(s.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked = TRUE WHERE id = $1 AND ($2 = '' OR phone = $2) AND NOT revoked`,
+		id.Hex(), phone,
+	))
+	if err == nil && affected == 0 {
+		return errSessionNotFound
+	}
+	return err
+}
+
+func (s *postgresSessionStore) RevokeAll(ctx context.Context, phone string, except primitive.ObjectID) error {
+	// A zero except matches no session, so nothing is excluded
+	return execOnly(s.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked = TRUE WHERE phone = $1 AND id <> $2 AND NOT revoked`, phone, except.Hex(),
+	))
+}
+
+func (s *postgresSessionStore) ChangePhone(ctx context.Context, oldPhone, newPhone string) error {
+	return execOnly(s.db.ExecContext(ctx, `UPDATE sessions SET phone = $2 WHERE phone = $1`, oldPhone, newPhone))
+}
+
+func (s *postgresSessionStore) DeleteByPhone(ctx context.Context, phone string) error {
+	return execOnly(s.db.ExecContext(ctx, `DELETE FROM sessions WHERE phone = $1`, phone))
+}
+
+const postgresRefreshTokenColumns = `id, hash, phone, family_id, used, revoked, created_at, expires_at`
+
+// postgresRefreshTokenStore хранит refresh-токены в таблице refresh_tokens.
+type postgresRefreshTokenStore struct {
+	db *sql.DB
+}
+
+func scanPostgresRefreshToken(row rowScanner) (RefreshToken, error) {
+	var token RefreshToken
+	err := row.Scan(
+		objectIDValue{&token.ID}, &token.Hash, &token.Phone, &token.FamilyID, &token.Used, &token.Revoked,
+		&token.CreatedAt, &token.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return RefreshToken{}, errRefreshTokenNotFound
+	}
+	return token, err
+}
+
+func (s *postgresRefreshTokenStore) Create(ctx context.Context, token RefreshToken) error {
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	return execOnly(s.db.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (`+postgresRefreshTokenColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		token.ID.Hex(), token.Hash, token.Phone, token.FamilyID, token.Used, token.Revoked,
+		token.CreatedAt, token.ExpiresAt,
+	))
+}
+
+func (s *postgresRefreshTokenStore) Use(ctx context.Context, hash string) (RefreshToken, error) {
+	token, err := scanPostgresRefreshToken(s.db.QueryRowContext(ctx,
+		`UPDATE refresh_tokens SET used = TRUE WHERE hash = $1 AND NOT used AND NOT revoked
+		RETURNING `+postgresRefreshTokenColumns,
+		hash,
+	))
+	// RETURNING yields the updated row, report the state before the update like the other stores
+	token.Used = false
+	return token, err
+}
+
+func (s *postgresRefreshTokenStore) Find(ctx context.Context, hash string) (RefreshToken, error) {
+	return scanPostgresRefreshToken(s.db.QueryRowContext(ctx,
+		`SELECT `+postgresRefreshTokenColumns+` FROM refresh_tokens WHERE hash = $1`, hash,
+	))
+}
+
+func (s *postgresRefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	return execOnly(s.db.ExecContext(ctx, `UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = $1`, familyID))
+}
+
+func (s *postgresRefreshTokenStore) RevokeByPhone(ctx context.Context, phone, exceptFamily string) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked = TRUE WHERE phone = $1 AND ($2 = '' OR family_id <> $2)`,
+		phone, exceptFamily,
+	))
+}
+
+func (s *postgresRefreshTokenStore) ChangePhone(ctx context.Context, oldPhone, newPhone string) error {
+	return execOnly(s.db.ExecContext(ctx, `UPDATE refresh_tokens SET phone = $2 WHERE phone = $1`, oldPhone, newPhone))
+}
+
+func (s *postgresRefreshTokenStore) DeleteByPhone(ctx context.Context, phone string) error {
+	return execOnly(s.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE phone = $1`, phone))
+}
+
+// postgresRevokedTokenStore хранит отозванные токены в таблице revoked_tokens.
+type postgresRevokedTokenStore struct {
+	db *sql.DB
+}
+
+func (s *postgresRevokedTokenStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`INSERT INTO revoked_tokens (jti, expires_at, revoked_at) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt, time.Now(),
+	))
+}
+
+func (s *postgresRevokedTokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	var revoked bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`, jti).Scan(&revoked)
+	return revoked, err
+}
+
+const postgresOTPColumns = `phone, purpose, code_hash, attempts, created_at, expires_at`
+
+// postgresOTPStore хранит коды в таблице otp_codes.
+type postgresOTPStore struct {
+	db *sql.DB
+}
+
+func (s *postgresOTPStore) Find(ctx context.Context, phone, purpose string) (OTPCode, error) {
+	var code OTPCode
+	err := s.db.QueryRowContext(ctx,
+		`SELECT `+postgresOTPColumns+` FROM otp_codes WHERE phone = $1 AND purpose = $2`, phone, purpose,
+	).Scan(&code.Phone, &code.Purpose, &code.CodeHash, &code.Attempts, &code.CreatedAt, &code.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OTPCode{}, errOTPNotFound
+	}
+	return code, err
+}
+
+func (s *postgresOTPStore) Save(ctx context.Context, code OTPCode) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`INSERT INTO otp_codes (`+postgresOTPColumns+`) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (phone, purpose) DO UPDATE SET
+			code_hash = EXCLUDED.code_hash, attempts = EXCLUDED.attempts,
+			created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at`,
+		code.Phone, code.Purpose, code.CodeHash, code.Attempts, code.CreatedAt, code.ExpiresAt,
+	))
+}
+
+func (s *postgresOTPStore) IncAttempts(ctx context.Context, phone, purpose string) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`UPDATE otp_codes SET attempts = attempts + 1 WHERE phone = $1 AND purpose = $2`, phone, purpose,
+	))
+}
+
+func (s *postgresOTPStore) Delete(ctx context.Context, phone, purpose, codeHash string) (bool, error) {
+	affected, err := rowsAffected(s.db.ExecContext(ctx,
+		`DELETE FROM otp_codes WHERE phone = $1 AND purpose = $2 AND code_hash = $3`, phone, purpose, codeHash,
+	))
+	return affected > 0, err
+}
+
+const postgresLoginAttemptColumns = `key, failures, last_failure, locked_until, expires_at`
+
+// postgresLoginAttemptStore хранит счетчики в таблице login_attempts.
+type postgresLoginAttemptStore struct {
+	db *sql.DB
+}
+
+func scanPostgresLoginAttempt(row rowScanner) (LoginAttempt, error) {
+	var attempt LoginAttempt
+	err := row.Scan(
+		&attempt.Key, &attempt.Failures, &attempt.LastFailure, timeValue{&attempt.LockedUntil}, &attempt.ExpiresAt,
+	)
+	return attempt, err
+}
+
+func (s *postgresLoginAttemptStore) Find(ctx context.Context, keys ...string) ([]LoginAttempt, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+postgresLoginAttemptColumns+` FROM login_attempts WHERE key = ANY ($1)`, pq.Array(keys),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []LoginAttempt
+	for rows.Next() {
+		attempt, err := scanPostgresLoginAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+func (s *postgresLoginAttemptStore) RecordFailure(ctx context.Context, key string, since, at, expiresAt time.Time) (LoginAttempt, error) {
+	// Start counting from scratch once the previous failures fall out of the window
+	return scanPostgresLoginAttempt(s.db.QueryRowContext(ctx,
+		`INSERT INTO login_attempts AS a (key, failures, last_failure, expires_at) VALUES ($1, 1, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN a.last_failure < $2 THEN 1 ELSE a.failures + 1 END,
+			last_failure = EXCLUDED.last_failure, expires_at = EXCLUDED.expires_at
+		RETURNING `+postgresLoginAttemptColumns,
+		key, since, at, expiresAt,
+	))
+}
+
+func (s *postgresLoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return execOnly(s.db.ExecContext(ctx, `UPDATE login_attempts SET locked_until = $2 WHERE key = $1`, key, until))
+}
+
+func (s *postgresLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	return execOnly(s.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1`, key))
+}
+
+const postgresAPIKeyColumns = `id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at`
+
+// postgresAPIKeyStore хранит API-ключи в таблице api_keys.
+type postgresAPIKeyStore struct {
+	db *sql.DB
+}
+
+func scanPostgresAPIKey(row rowScanner) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		objectIDValue{&key.ID}, objectIDValue{&key.UserID}, &key.Name, &key.Prefix, &key.KeyHash,
+		pq.Array(&key.Scopes), &key.CreatedAt, timeValue{&key.LastUsedAt},
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return APIKey{}, errAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (s *postgresAPIKeyStore) Create(ctx context.Context, key APIKey) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`INSERT INTO api_keys (`+postgresAPIKeyColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		key.ID.Hex(), key.UserID.Hex(), key.Name, key.Prefix, key.KeyHash,
+		pq.Array(nonNilStrings(key.Scopes)), key.CreatedAt, nullTime(key.LastUsedAt),
+	))
+}
+
+func (s *postgresAPIKeyStore) FindByHash(ctx context.Context, hash string) (APIKey, error) {
+	return scanPostgresAPIKey(s.db.QueryRowContext(ctx,
+		`SELECT `+postgresAPIKeyColumns+` FROM api_keys WHERE key_hash = $1`, hash,
+	))
+}
+
+func (s *postgresAPIKeyStore) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+postgresAPIKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY id`, userID.Hex(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanPostgresAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresAPIKeyStore) Rename(ctx context.Context, id, userID primitive.ObjectID, name string) error {
+	affected, err := rowsAffected(s.db.ExecContext(ctx,
+		`UPDATE api_keys SET name = $3 WHERE id = $1 AND user_id = $2`, id.Hex(), userID.Hex(), name,
+	))
+	if err == nil && affected == 0 {
+		return errAPIKeyNotFound
+	}
+	return err
+}
+
+func (s *postgresAPIKeyStore) Touch(ctx context.Context, id primitive.ObjectID, at time.Time) error {
+	return execOnly(s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, id.Hex(), at))
+}
+
+func (s *postgresAPIKeyStore) Delete(ctx context.Context, id, userID primitive.ObjectID) error {
+	affected, err := rowsAffected(s.db.ExecContext(ctx,
+		`DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id.Hex(), userID.Hex(),
+	))
+	if err == nil && affected == 0 {
+		return errAPIKeyNotFound
+	}
+	return err
+}
+
+func (s *postgresAPIKeyStore) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	return execOnly(s.db.ExecContext(ctx, `DELETE FROM api_keys WHERE user_id = $1`, userID.Hex()))
+}
+
+const postgresWebAuthnCredentialColumns = `id, user_id, name, credential_id, public_key, attestation_type,
+	transports, aaguid, sign_count, attachment, backup_eligible, backup_state, created_at, last_used_at`
+
+// postgresWebAuthnCredentialStore хранит ключи в таблице webauthn_credentials.
+type postgresWebAuthnCredentialStore struct {
+	db *sql.DB
+}
+
+func (s *postgresWebAuthnCredentialStore) Create(ctx context.Context, credential WebAuthnCredential) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO webauthn_credentials (`+postgresWebAuthnCredentialColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		credential.ID.Hex(), credential.UserID.Hex(), credential.Name, credential.CredentialID,
+		credential.PublicKey, credential.AttestationType, pq.Array(nonNilStrings(credential.Transports)),
+		credential.AAGUID, int64(credential.SignCount), credential.Attachment, credential.BackupEligible,
+		credential.BackupState, credential.CreatedAt, nullTime(credential.LastUsedAt),
+	)
+	if isUniqueViolation(err) {
+		return errWebAuthnCredentialExists
+	}
+	return err
+}
+
+func (s *postgresWebAuthnCredentialStore) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]WebAuthnCredential, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+postgresWebAuthnCredentialColumns+` FROM webauthn_credentials WHERE user_id = $1 ORDER BY id`,
+		userID.Hex(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := []WebAuthnCredential{}
+	for rows.Next() {
+		var credential WebAuthnCredential
+		err := rows.Scan(
+			objectIDValue{&credential.ID}, objectIDValue{&credential.UserID}, &credential.Name,
+			&credential.CredentialID, &credential.PublicKey, &credential.AttestationType,
+			pq.Array(&credential.Transports), &credential.AAGUID, &credential.SignCount, &credential.Attachment,
+			&credential.BackupEligible, &credential.BackupState, &credential.CreatedAt,
+			timeValue{&credential.LastUsedAt},
+		)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials, rows.Err()
+}
+
+func (s *postgresWebAuthnCredentialStore) RecordUse(ctx context.Context, credentialID []byte, signCount uint32, backupState bool, at time.Time) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`UPDATE webauthn_credentials SET sign_count = $2, backup_state = $3, last_used_at = $4 WHERE credential_id = $1`,
+		credentialID, int64(signCount), backupState, at,
+	))
+}
+
+func (s *postgresWebAuthnCredentialStore) Delete(ctx context.Context, id, userID primitive.ObjectID) error {
+	affected, err := rowsAffected(s.db.ExecContext(ctx,
+		`DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2`, id.Hex(), userID.Hex(),
+	))
+	if err == nil && affected == 0 {
+		return errWebAuthnCredentialNotFound
+	}
+	return err
+}
+
+func (s *postgresWebAuthnCredentialStore) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	return execOnly(s.db.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE user_id = $1`, userID.Hex()))
+}
+
+// postgresWebAuthnCeremonyStore хранит церемонии в таблице webauthn_ceremonies.
+type postgresWebAuthnCeremonyStore struct {
+	db *sql.DB
+}
+
+func (s *postgresWebAuthnCeremonyStore) Save(ctx context.Context, ceremony WebAuthnCeremony) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`INSERT INTO webauthn_ceremonies (challenge, purpose, user_id, session, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		ceremony.Challenge, ceremony.Purpose, nullObjectID(ceremony.UserID), ceremony.Session, ceremony.ExpiresAt,
+	))
+}
+
+func (s *postgresWebAuthnCeremonyStore) Take(ctx context.Context, purpose, challenge string, now time.Time) (WebAuthnCeremony, error) {
+	var ceremony WebAuthnCeremony
+	err := s.db.QueryRowContext(ctx,
+		`DELETE FROM webauthn_ceremonies WHERE challenge = $1 AND purpose = $2 AND expires_at > $3
+		RETURNING challenge, purpose, user_id, session, expires_at`,
+		challenge, purpose, now,
+	).Scan(&ceremony.Challenge, &ceremony.Purpose, objectIDValue{&ceremony.UserID}, &ceremony.Session, &ceremony.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebAuthnCeremony{}, errWebAuthnCeremonyNotFound
+	}
+	return ceremony, err
+}
+
+const postgresOAuthClientColumns = `id, client_id, secret_hash, name, redirect_uris, grant_types, scopes,
+	confidential, created_at`
+
+// postgresOAuthClientStore хранит клиентов в таблице oauth_clients.
+type postgresOAuthClientStore struct {
+	db *sql.DB
+}
+
+func (s *postgresOAuthClientStore) Create(ctx context.Context, client OAuthClient) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`INSERT INTO oauth_clients (`+postgresOAuthClientColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		client.ID.Hex(), client.ClientID, client.SecretHash, client.Name,
+		pq.Array(nonNilStrings(client.RedirectURIs)), pq.Array(nonNilStrings(client.GrantTypes)),
+		pq.Array(nonNilStrings(client.Scopes)), client.Confidential, client.CreatedAt,
+	))
+}
+
+func (s *postgresOAuthClientStore) FindByClientID(ctx context.Context, clientID string) (OAuthClient, error) {
+	var client OAuthClient
+	err := s.db.QueryRowContext(ctx,
+		`SELECT `+postgresOAuthClientColumns+` FROM oauth_clients WHERE client_id = $1`, clientID,
+	).Scan(
+		objectIDValue{&client.ID}, &client.ClientID, &client.SecretHash, &client.Name,
+		pq.Array(&client.RedirectURIs), pq.Array(&client.GrantTypes), pq.Array(&client.Scopes),
+		&client.Confidential, &client.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OAuthClient{}, errInvalidClient
+	}
+	return client, err
+}
+
+const postgresAuthorizationCodeColumns = `hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge,
+	auth_time, used, expires_at`
+
+// postgresAuthorizationCodeStore хранит коды в таблице oauth_codes.
+type postgresAuthorizationCodeStore struct {
+	db *sql.DB
+}
+
+func (s *postgresAuthorizationCodeStore) Create(ctx context.Context, code AuthorizationCode) error {
+	return execOnly(s.db.ExecContext(ctx,
+		`INSERT INTO oauth_codes (`+postgresAuthorizationCodeColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		code.Hash, code.ClientID, code.UserID.Hex(), code.RedirectURI, code.Scope, code.Nonce, code.CodeChallenge,
+		code.AuthTime, code.Used, code.ExpiresAt,
+	))
+}
+
+func (s *postgresAuthorizationCodeStore) Use(ctx context.Context, hash, clientID string) (AuthorizationCode, error) {
+	var code AuthorizationCode
+	err := s.db.QueryRowContext(ctx,
+		`UPDATE oauth_codes SET used = TRUE WHERE hash = $1 AND client_id = $2 AND NOT used
+		RETURNING `+postgresAuthorizationCodeColumns,
+		hash, clientID,
+	).Scan(
+		&code.Hash, &code.ClientID, objectIDValue{&code.UserID}, &code.RedirectURI, &code.Scope, &code.Nonce,
+		&code.CodeChallenge, &code.AuthTime, &code.Used, &code.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AuthorizationCode{}, errInvalidGrant
+	}
+	// RETURNING yields the updated row, report the state before the update like the other stores
+	code.Used = false
+	return code, err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errPostNotFound = errors.New("post not found")
+
+// PostStore хранит посты. Нулевой authorID в Update и Delete снимает проверку автора,
+// иначе пост другого автора считается ненайденным и возвращается errPostNotFound.
+type PostStore interface {
+	Create(ctx context.Context, post Post) error
+	// List возвращает все посты в порядке создания.
+	List(ctx context.Context) ([]Post, error)
+	// Update меняет заголовок, текст, картинку и дату поста.
+	Update(ctx context.Context, id, authorID primitive.ObjectID, post Post) error
+	Delete(ctx context.Context, id, authorID primitive.ObjectID) error
+	DeleteByAuthor(ctx context.Context, authorID primitive.ObjectID) error
+}
+
+// mongoPostStore хранит посты в коллекции MongoDB.
+type mongoPostStore struct {
+	collection *mongo.Collection
+}
+
+// postFilter выбирает пост по идентификатору и, если указан, по автору.
+func postFilter(id, authorID primitive.ObjectID) bson.M {
+	filter := bson.M{"_id": id}
+	if !authorID.IsZero() {
+		filter["author_id"] = authorID
+	}
+	return filter
+}
+
+func (s *mongoPostStore) Create(ctx context.Context, post Post) error {
+	_, err := s.collection.InsertOne(ctx, post)
+	return err
+}
+
+func (s *mongoPostStore) List(ctx context.Context) ([]Post, error) {
+	cursor, err := s.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	posts := []Post{}
+	if err = cursor.All(ctx, &posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (s *mongoPostStore) Update(ctx context.Context, id, authorID primitive.ObjectID, post Post) error {
+	result, err := s.collection.UpdateOne(ctx, postFilter(id, authorID), bson.M{
+		"$set": bson.M{
+			"title": post.Title,
+			"text":  post.Text,
+			"image": post.Image,
+			"date":  post.Date,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errPostNotFound
+	}
+	return nil
+}
+
+func (s *mongoPostStore) Delete(ctx context.Context, id, authorID primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, postFilter(id, authorID))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errPostNotFound
+	}
+	return nil
+}
+
+func (s *mongoPostStore) DeleteByAuthor(ctx context.Context, authorID primitive.ObjectID) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"author_id": authorID})
+	return err
+}
+
+// memoryPostStore хранит посты в памяти процесса. Подходит для тестов и локальной разработки.
+type memoryPostStore struct {
+	mu    sync.Mutex
+	posts map[primitive.ObjectID]Post
+}
+
+func newMemoryPostStore() *memoryPostStore {
+	return &memoryPostStore{posts: make(map[primitive.ObjectID]Post)}
+}
+
+// find возвращает пост, если он существует и принадлежит автору. Вызывается под блокировкой.
+func (s *memoryPostStore) find(id, authorID primitive.ObjectID) (Post, bool) {
+	post, ok := s.posts[id]
+	if !ok || (!authorID.IsZero() && post.AuthorID != authorID) {
+		return Post{}, false
+	}
+	return post, true
+}
+
+func (s *memoryPostStore) Create(_ context.Context, post Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if post.ID.IsZero() {
+		post.ID = primitive.NewObjectID()
+	}
+	s.posts[post.ID] = post
+	return nil
+}
+
+func (s *memoryPostStore) List(_ context.Context) ([]Post, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	posts := make([]Post, 0, len(s.posts))
+	for _, post := range s.posts {
+		posts = append(posts, post)
+	}
+	// ObjectIDs grow with creation time, same order as the MongoDB store
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].ID.Hex() < posts[j].ID.Hex()
+	})
+	return posts, nil
+}
+
+func (s *memoryPostStore) Update(_ context.Context, id, authorID primitive.ObjectID, post Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.find(id, authorID)
+	if !ok {
+		return errPostNotFound
+	}
+	stored.Title = post.Title
+	stored.Text = post.Text
+	stored.Image = post.Image
+	stored.Date = post.Date
+	s.posts[id] = stored
+	return nil
+}
+
+func (s *memoryPostStore) Delete(_ context.Context, id, authorID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.find(id, authorID); !ok {
+		return errPostNotFound
+	}
+	delete(s.posts, id)
+	return nil
+}
+
+func (s *memoryPostStore) DeleteByAuthor(_ context.Context, authorID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, post := range s.posts {
+		if post.AuthorID == authorID {
+			delete(s.posts, id)
+		}
+	}
+	return nil
+}
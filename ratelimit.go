@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RateLimit задает token bucket: до Requests запросов подряд, которые восстанавливаются равномерно за Per.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ratePerSecond возвращает скорость пополнения корзины.
+func (l RateLimit) ratePerSecond() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// RateLimitResult описывает состояние корзины после попытки взять токен.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// newRateLimitResult вычисляет результат по числу оставшихся в корзине токенов.
+func newRateLimitResult(allowed bool, tokens float64, limit RateLimit) RateLimitResult {
+	rate := limit.ratePerSecond()
+	result := RateLimitResult{
+		Allowed:   allowed,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Requests) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return result
+}
+
+// RateLimitStore хранит корзины токенов по ключам.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// memoryRateLimitStore хранит корзины в памяти процесса. Подходит для одного экземпляра сервиса.
+type memoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	idleTTL time.Duration
+}
+
+func newMemoryRateLimitStore() *memoryRateLimitStore {
+	return &memoryRateLimitStore{buckets: make(map[string]*tokenBucket), lastSweep: time.Now()}
+}
+
+func (s *memoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(limit.Requests), updated: now, idleTTL: limit.Per}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Requests), b.tokens+now.Sub(b.updated).Seconds()*limit.ratePerSecond())
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newRateLimitResult(allowed, b.tokens, limit), nil
+}
+
+// sweep раз в минуту удаляет корзины, которые успели полностью восстановиться.
+func (s *memoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) > b.idleTTL {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// mongoRateLimitStore хранит корзины в MongoDB, чтобы лимиты были общими для нескольких экземпляров.
+// Корзина обновляется атомарно одним конвейером обновления. Если два запроса одновременно создают
+// новую корзину, вставка одного из них завершается ошибкой дубликата ключа, и он повторяется
+// уже как обновление существующей корзины.
+type mongoRateLimitStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+	capacity := float64(limit.Requests)
+	elapsedSeconds := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated_at", now}}}}, 1000}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"tokens": bson.M{"$min": bson.A{capacity, bson.M{"$add": bson.A{
+				bson.M{"$ifNull": bson.A{"$tokens", capacity}},
+				bson.M{"$multiply": bson.A{elapsedSeconds, limit.ratePerSecond()}},
+			}}}},
+			"updated_at": now,
+			"expires_at": now.Add(limit.Per),
+		}}},
+		{{Key: "$set", Value: bson.M{"allowed": bson.M{"$gte": bson.A{"$tokens", 1}}}}},
+		{{Key: "$set", Value: bson.M{"tokens": bson.M{"$cond": bson.A{"$allowed", bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}}}}},
+	}
+
+	var bucket struct {
+		Tokens  float64 `bson:"tokens"`
+		Allowed bool    `bson:"allowed"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&bucket)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another request inserted the bucket first, now the filter matches it
+		err = s.collection.FindOneAndUpdate(ctx, bson.M{"_id": key}, pipeline, opts).Decode(&bucket)
+	}
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	return newRateLimitResult(bucket.Allowed, bucket.Tokens, limit), nil
+}
+
+// postgresRateLimitStore хранит корзины в таблице rate_limits PostgreSQL.
+// Строка корзины заблокирована от пополнения до списания токена, поэтому запросы не теряются при гонке.
+type postgresRateLimitStore struct {
+	db *sql.DB
+}
+
+func (s *postgresRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	defer tx.Rollback()
+
+	var tokens float64
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO rate_limits AS b (key, tokens, updated_at, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = LEAST(EXCLUDED.tokens, b.tokens + EXTRACT(EPOCH FROM EXCLUDED.updated_at - b.updated_at)::DOUBLE PRECISION * $5),
+			updated_at = EXCLUDED.updated_at, expires_at = EXCLUDED.expires_at
+		RETURNING tokens`,
+		key, float64(limit.Requests), now, now.Add(limit.Per), limit.ratePerSecond(),
+	).Scan(&tokens)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+		if _, err := tx.ExecContext(ctx, `UPDATE rate_limits SET tokens = $2 WHERE key = $1`, key, tokens); err != nil {
+			return RateLimitResult{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return RateLimitResult{}, err
+	}
+	return newRateLimitResult(allowed, tokens, limit), nil
+}
+
+// initRateLimit выбирает хранилище лимитов по RATE_LIMIT_STORE: "memory" (по умолчанию), "mongo" или "postgres".
+// Общие хранилища используют базу основного хранилища: database для mongo и postgres для postgres.
+func initRateLimit(postgres *sql.DB) RateLimitStore {
+	switch store := config.RateLimitStore; store {
+	case "", "memory":
+		return newMemoryRateLimitStore()
+	case "mongo":
+		return &mongoRateLimitStore{collection: database.Collection("rate_limits")}
+	case "postgres":
+		return &postgresRateLimitStore{db: postgres}
+	default:
+		log.Fatalf("unknown RATE_LIMIT_STORE %q", store)
+		return nil
+	}
+}
+
+// RateLimitKeyFunc возвращает ключ, по которому считается лимит запроса.
+type RateLimitKeyFunc func(c *gin.Context) string
+
+// KeyByIP считает лимит по IP-адресу клиента.
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyByPhone считает лимит по телефону аутентифицированного пользователя, а без аутентификации - по IP.
+// Используется после AuthMiddleware.
+func KeyByPhone(c *gin.Context) string {
+	if phone := c.GetString("phone"); phone != "" {
+		return "phone:" + phone
+	}
+	return KeyByIP(c)
+}
+
+// KeyByAPIKey считает лимит по API-ключу из заголовка "Authorization: ApiKey ...", а без него - как KeyByPhone.
+// В ключе хранится хеш, а не сам API-ключ. Используется после AuthMiddleware.
+func KeyByAPIKey(c *gin.Context) string {
+	if apiKey, ok := strings.CutPrefix(c.GetHeader("Authorization"), "ApiKey "); ok && apiKey != "" {
+		return "apikey:" + hashToken(apiKey)
+	}
+	return KeyByPhone(c)
+}
+
+// RateLimitMiddleware ограничивает частоту запросов к маршруту name по ключу keyFunc.
+// Отвечает заголовками RateLimit-*, а при превышении - 429 с Retry-After.
+// Если хранилище недоступно, запрос пропускается, чтобы сбой лимитера не останавливал сервис.
+func (h *Handler) RateLimitMiddleware(name string, limit RateLimit, keyFunc RateLimitKeyFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := h.rateLimits.Take(context.Background(), name+":"+keyFunc(c), limit)
+		if err != nil {
+			log.Println("Error checking rate limit:", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.Reset.Seconds()))))
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
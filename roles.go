@@ -0,0 +1,35 @@
+package main
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+const (
+	PermissionPostsModerate = "posts:moderate"
+	PermissionUsersManage   = "users:manage"
+)
+
+// rolePermissions описывает, какие разрешения выдает каждая роль.
+var rolePermissions = map[string][]string{
+	RoleUser:      {},
+	RoleModerator: {PermissionPostsModerate},
+	RoleAdmin:     {PermissionPostsModerate, PermissionUsersManage},
+}
+
+// validRole проверяет, что роль известна системе.
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// hasPermission проверяет, выдает ли роль указанное разрешение.
+func hasPermission(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
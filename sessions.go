@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errSessionRevoked  = errors.New("session revoked")
+	errAccountDisabled = errors.New("account disabled")
+)
+
+// createSession открывает новую сессию пользователя, запоминая устройство, IP и User-Agent запроса.
+func (h *Handler) createSession(c *gin.Context, phone string) (Session, error) {
+	now := time.Now()
+	session := Session{
+		ID:         primitive.NewObjectID(),
+		Phone:      phone,
+		Device:     c.GetHeader("X-Device-Name"),
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+
+	err := h.sessions.Create(context.Background(), session)
+	return session, err
+}
+
+// validateSession проверяет, что аккаунт не заблокирован, а токен не был отозван через смену версии
+// токенов пользователя или завершение сессии, обновляет время последней активности сессии
+// и возвращает владельца токена.
+func (h *Handler) validateSession(ctx context.Context, claims *Claims, ip string) (User, error) {
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return User{}, errSessionRevoked
+	}
+	user, err := h.users.FindByID(ctx, userID)
+	if errors.Is(err, errUserNotFound) || (err == nil && user.Phone != claims.Phone) {
+		return User{}, errSessionRevoked
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if user.Disabled {
+		return User{}, errAccountDisabled
+	}
+	if user.TokenVersion != claims.TokenVersion {
+		return User{}, errSessionRevoked
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(claims.SessionID)
+	if err != nil {
+		return User{}, errSessionRevoked
+	}
+	err = h.sessions.Touch(ctx, sessionID, claims.Phone, ip, time.Now())
+	if errors.Is(err, errSessionNotFound) {
+		return User{}, errSessionRevoked
+	}
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+// revokeSession завершает сессию по ее идентификатору.
+func (h *Handler) revokeSession(ctx context.Context, sessionID string) error {
+	objID, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return err
+	}
+	err = h.sessions.Revoke(ctx, objID, "")
+	if errors.Is(err, errSessionNotFound) {
+		return nil
+	}
+	return err
+}
+
+// revokeAllSessions завершает все сессии пользователя и делает недействительными все выданные ему токены.
+func (h *Handler) revokeAllSessions(ctx context.Context, user User) error {
+	if _, err := h.users.Update(ctx, user.ID, UserUpdate{IncTokenVersion: true}); err != nil {
+		return err
+	}
+
+	if err := h.sessions.RevokeAll(ctx, user.Phone, primitive.NilObjectID); err != nil {
+		return err
+	}
+	return h.refreshTokens.RevokeByPhone(ctx, user.Phone, "")
+}
+
+// revokeOtherSessions завершает все сессии пользователя, кроме текущей, вместе с их refresh-токенами.
+func (h *Handler) revokeOtherSessions(ctx context.Context, phone, currentSessionID string) error {
+	if err := h.sessions.RevokeAll(ctx, phone, sessionObjectID(currentSessionID)); err != nil {
+		return err
+	}
+	return h.refreshTokens.RevokeByPhone(ctx, phone, currentSessionID)
+}
+
+// changeSessionsPhone переносит сессии и refresh-токены пользователя на новый номер телефона.
+func (h *Handler) changeSessionsPhone(ctx context.Context, oldPhone, newPhone string) error {
+	if err := h.sessions.ChangePhone(ctx, oldPhone, newPhone); err != nil {
+		return err
+	}
+	return h.refreshTokens.ChangePhone(ctx, oldPhone, newPhone)
+}
+
+// sessionObjectID преобразует идентификатор сессии из токена в ObjectID; некорректный идентификатор дает нулевой ObjectID.
+func sessionObjectID(sessionID string) primitive.ObjectID {
+	objID, _ := primitive.ObjectIDFromHex(sessionID)
+	return objID
+}
+
+// ListSessions godoc
+//
+//	@Summary		List active sessions
+//	@Description	List active sessions of the authenticated user
+//	@Tags			Session
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{array}		Session				"List of sessions"
+//	@Failure		500	{object}	map[string]string	"Failed to fetch sessions"
+//	@Router			/protected/sessions [get]
+func (h *Handler) ListSessions(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	sessions, err := h.sessions.ListActive(context.Background(), claims.Phone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID.Hex() == claims.SessionID
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// DeleteSession godoc
+//
+//	@Summary		Terminate a session
+//	@Description	Terminate one of the authenticated user's sessions and revoke its refresh tokens
+//	@Tags			Session
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string				true	"Session ID"
+//	@Success		200	{object}	map[string]string	"Session terminated successfully"
+//	@Failure		400	{object}	map[string]string	"Invalid session ID"
+//	@Failure		404	{object}	map[string]string	"Session not found"
+//	@Failure		500	{object}	map[string]string	"Failed to terminate session"
+//	@Router			/protected/sessions/{id} [delete]
+func (h *Handler) DeleteSession(c *gin.Context) {
+	claims := c.MustGet("claims").(*Claims)
+
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	// Ensure the session belongs to the authenticated user
+	err = h.sessions.Revoke(context.Background(), objID, claims.Phone)
+	if errors.Is(err, errSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate session"})
+		return
+	}
+
+	if err := h.revokeRefreshTokenFamily(context.Background(), objID.Hex()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session terminated successfully"})
+}
+
+// RevokeAllSessions godoc
+//
+//	@Summary		Log out everywhere
+//	@Description	Terminate every session of the authenticated user and invalidate all outstanding tokens
+//	@Tags			Session
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{object}	map[string]string	"All sessions terminated successfully"
+//	@Failure		500	{object}	map[string]string	"Failed to terminate sessions"
+//	@Router			/protected/sessions/revoke-all [post]
+func (h *Handler) RevokeAllSessions(c *gin.Context) {
+	if err := h.revokeAllSessions(context.Background(), currentUser(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to terminate sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "All sessions terminated successfully"})
+}
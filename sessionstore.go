@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errSessionNotFound      = errors.New("session not found")
+	errRefreshTokenNotFound = errors.New("refresh token not found")
+)
+
+// SessionStore хранит сессии пользователей. Сессии привязаны к телефону владельца.
+type SessionStore interface {
+	Create(ctx context.Context, session Session) error
+	// Touch обновляет время последней активности и IP незавершенной сессии владельца phone.
+	// Возвращает errSessionNotFound, если такой сессии нет или она завершена.
+	Touch(ctx context.Context, id primitive.ObjectID, phone, ip string, at time.Time) error
+	// Find возвращает незавершенную сессию владельца phone или errSessionNotFound.
+	Find(ctx context.Context, id primitive.ObjectID, phone string) (Session, error)
+	// ListActive возвращает незавершенные сессии владельца в порядке создания.
+	ListActive(ctx context.Context, phone string) ([]Session, error)
+	// Revoke завершает сессию. Пустой phone снимает проверку владельца.
+	// Возвращает errSessionNotFound, если подходящей незавершенной сессии нет.
+	Revoke(ctx context.Context, id primitive.ObjectID, phone string) error
+	// RevokeAll завершает все сессии владельца, кроме except; нулевой except не исключает ни одной.
+	RevokeAll(ctx context.Context, phone string, except primitive.ObjectID) error
+	ChangePhone(ctx context.Context, oldPhone, newPhone string) error
+	DeleteByPhone(ctx context.Context, phone string) error
+}
+
+// RefreshTokenStore хранит хеши refresh-токенов. Семейство токенов совпадает с идентификатором сессии.
+type RefreshTokenStore interface {
+	Create(ctx context.Context, token RefreshToken) error
+	// Use помечает токен использованным, если он еще не использован и не отозван, и возвращает его.
+	// Возвращает errRefreshTokenNotFound, если такого токена нет или его уже нельзя использовать.
+	Use(ctx context.Context, hash string) (RefreshToken, error)
+	// Find возвращает токен по хешу в любом состоянии или errRefreshTokenNotFound.
+	Find(ctx context.Context, hash string) (RefreshToken, error)
+	RevokeFamily(ctx context.Context, familyID string) error
+	// RevokeByPhone отзывает токены владельца, кроме семейства exceptFamily; пустое значение не исключает ни одного.
+	RevokeByPhone(ctx context.Context, phone, exceptFamily string) error
+	ChangePhone(ctx context.Context, oldPhone, newPhone string) error
+	DeleteByPhone(ctx context.Context, phone string) error
+}
+
+// RevokedTokenStore хранит jti отозванных access-токенов до истечения их срока действия.
+type RevokedTokenStore interface {
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// mongoSessionStore хранит сессии в коллекции MongoDB.
+type mongoSessionStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoSessionStore) Create(ctx context.Context, session Session) error {
+	_, err := s.collection.InsertOne(ctx, session)
+	return err
+}
+
+func (s *mongoSessionStore) Touch(ctx context.Context, id primitive.ObjectID, phone, ip string, at time.Time) error {
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "phone": phone, "revoked": false},
+		bson.M{"$set": bson.M{"last_seen_at": at, "ip": ip}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errSessionNotFound
+	}
+	return nil
+}
+
+func (s *mongoSessionStore) Find(ctx context.Context, id primitive.ObjectID, phone string) (Session, error) {
+	var session Session
+	err := s.collection.FindOne(ctx, bson.M{"_id": id, "phone": phone, "revoked": false}).Decode(&session)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Session{}, errSessionNotFound
+	}
+	return session, err
+}
+
+func (s *mongoSessionStore) ListActive(ctx context.Context, phone string) ([]Session, error) {
+	cursor, err := s.collection.Find(ctx,
+		bson.M{"phone": phone, "revoked": false},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []Session{}
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *mongoSessionStore) Revoke(ctx context.Context, id primitive.ObjectID, phone string) error {
+	filter := bson.M{"_id": id, "revoked": false}
+	if phone != "" {
+		filter["phone"] = phone
+	}
+	result, err := s.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errSessionNotFound
+	}
+	return nil
+}
+
+func (s *mongoSessionStore) RevokeAll(ctx context.Context, phone string, except primitive.ObjectID) error {
+	filter := bson.M{"phone": phone, "revoked": false}
+	if !except.IsZero() {
+		filter["_id"] = bson.M{"$ne": except}
+	}
+	_, err := s.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+func (s *mongoSessionStore) ChangePhone(ctx context.Context, oldPhone, newPhone string) error {
+	_, err := s.collection.UpdateMany(ctx, bson.M{"phone": oldPhone}, bson.M{"$set": bson.M{"phone": newPhone}})
+	return err
+}
+
+func (s *mongoSessionStore) DeleteByPhone(ctx context.Context, phone string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"phone": phone})
+	return err
+}
+
+// mongoRefreshTokenStore хранит refresh-токены в коллекции MongoDB.
+type mongoRefreshTokenStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRefreshTokenStore) Create(ctx context.Context, token RefreshToken) error {
+	_, err := s.collection.InsertOne(ctx, token)
+	return err
+}
+
+func (s *mongoRefreshTokenStore) Use(ctx context.Context, hash string) (RefreshToken, error) {
+	var token RefreshToken
+	err := s.collection.FindOneAndUpdate(ctx,
+		bson.M{"hash": hash, "used": false, "revoked": false},
+		bson.M{"$set": bson.M{"used": true, "used_at": time.Now()}},
+	).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return RefreshToken{}, errRefreshTokenNotFound
+	}
+	return token, err
+}
+
+func (s *mongoRefreshTokenStore) Find(ctx context.Context, hash string) (RefreshToken, error) {
+	var token RefreshToken
+	err := s.collection.FindOne(ctx, bson.M{"hash": hash}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return RefreshToken{}, errRefreshTokenNotFound
+	}
+	return token, err
+}
+
+func (s *mongoRefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := s.collection.UpdateMany(ctx, bson.M{"family_id": familyID}, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+func (s *mongoRefreshTokenStore) RevokeByPhone(ctx context.Context, phone, exceptFamily string) error {
+	filter := bson.M{"phone": phone}
+	if exceptFamily != "" {
+		filter["family_id"] = bson.M{"$ne": exceptFamily}
+	}
+	_, err := s.collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}})
+	return err
+}
+
+func (s *mongoRefreshTokenStore) ChangePhone(ctx context.Context, oldPhone, newPhone string) error {
+	_, err := s.collection.UpdateMany(ctx, bson.M{"phone": oldPhone}, bson.M{"$set": bson.M{"phone": newPhone}})
+	return err
+}
+
+func (s *mongoRefreshTokenStore) DeleteByPhone(ctx context.Context, phone string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"phone": phone})
+	return err
+}
+
+// mongoRevokedTokenStore хранит отозванные токены в коллекции MongoDB. Записи удаляет TTL-индекс.
+type mongoRevokedTokenStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoRevokedTokenStore) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"jti": jti},
+		bson.M{"$setOnInsert": RevokedToken{JTI: jti, ExpiresAt: expiresAt, RevokedAt: time.Now()}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (s *mongoRevokedTokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	err := s.collection.FindOne(ctx, bson.M{"jti": jti}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// memorySessionStore хранит сессии в памяти процесса. Подходит для тестов и локальной разработки.
+type memorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[primitive.ObjectID]Session
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{sessions: make(map[primitive.ObjectID]Session)}
+}
+
+func (s *memorySessionStore) Create(_ context.Context, session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *memorySessionStore) Touch(_ context.Context, id primitive.ObjectID, phone, ip string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.Phone != phone || session.Revoked {
+		return errSessionNotFound
+	}
+	session.LastSeenAt = at
+	session.IP = ip
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *memorySessionStore) Find(_ context.Context, id primitive.ObjectID, phone string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.Phone != phone || session.Revoked {
+		return Session{}, errSessionNotFound
+	}
+	return session, nil
+}
+
+func (s *memorySessionStore) ListActive(_ context.Context, phone string) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := []Session{}
+	for _, session := range s.sessions {
+		if session.Phone == phone && !session.Revoked {
+			sessions = append(sessions, session)
+		}
+	}
+	// ObjectIDs grow with creation time, same order as the MongoDB store
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID.Hex() < sessions[j].ID.Hex()
+	})
+	return sessions, nil
+}
+
+func (s *memorySessionStore) Revoke(_ context.Context, id primitive.ObjectID, phone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.Revoked || (phone != "" && session.Phone != phone) {
+		return errSessionNotFound
+	}
+	session.Revoked = true
+	s.sessions[id] = session
+	return nil
+}
+
+func (s *memorySessionStore) RevokeAll(_ context.Context, phone string, except primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.Phone == phone && id != except {
+			session.Revoked = true
+			s.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (s *memorySessionStore) ChangePhone(_ context.Context, oldPhone, newPhone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.Phone == oldPhone {
+			session.Phone = newPhone
+			s.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (s *memorySessionStore) DeleteByPhone(_ context.Context, phone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, session := range s.sessions {
+		if session.Phone == phone {
+			delete(s.sessions, id)
+		}
+	}
+	return nil
+}
+
+// memoryRefreshTokenStore хранит refresh-токены в памяти процесса по хешу.
+type memoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+func newMemoryRefreshTokenStore() *memoryRefreshTokenStore {
+	return &memoryRefreshTokenStore{tokens: make(map[string]RefreshToken)}
+}
+
+// update применяет изменение ко всем токенам, подходящим под условие. Вызывается под блокировкой.
+func (s *memoryRefreshTokenStore) update(match func(RefreshToken) bool, change func(*RefreshToken)) {
+	for hash, token := range s.tokens {
+		if match(token) {
+			change(&token)
+			s.tokens[hash] = token
+		}
+	}
+}
+
+func (s *memoryRefreshTokenStore) Create(_ context.Context, token RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token.ID.IsZero() {
+		token.ID = primitive.NewObjectID()
+	}
+	s.tokens[token.Hash] = token
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) Use(_ context.Context, hash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hash]
+	if !ok || token.Used || token.Revoked {
+		return RefreshToken{}, errRefreshTokenNotFound
+	}
+	result := token
+	token.Used = true
+	s.tokens[hash] = token
+	// Return the state before the update, same as the MongoDB store
+	return result, nil
+}
+
+func (s *memoryRefreshTokenStore) Find(_ context.Context, hash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hash]
+	if !ok {
+		return RefreshToken{}, errRefreshTokenNotFound
+	}
+	return token, nil
+}
+
+func (s *memoryRefreshTokenStore) RevokeFamily(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.update(
+		func(token RefreshToken) bool { return token.FamilyID == familyID },
+		func(token *RefreshToken) { token.Revoked = true },
+	)
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) RevokeByPhone(_ context.Context, phone, exceptFamily string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.update(
+		func(token RefreshToken) bool {
+			return token.Phone == phone && (exceptFamily == "" || token.FamilyID != exceptFamily)
+		},
+		func(token *RefreshToken) { token.Revoked = true },
+	)
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) ChangePhone(_ context.Context, oldPhone, newPhone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.update(
+		func(token RefreshToken) bool { return token.Phone == oldPhone },
+		func(token *RefreshToken) { token.Phone = newPhone },
+	)
+	return nil
+}
+
+func (s *memoryRefreshTokenStore) DeleteByPhone(_ context.Context, phone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, token := range s.tokens {
+		if token.Phone == phone {
+			delete(s.tokens, hash)
+		}
+	}
+	return nil
+}
+
+// memoryRevokedTokenStore хранит отозванные jti в памяти процесса вместе со сроком их действия.
+type memoryRevokedTokenStore struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+}
+
+func newMemoryRevokedTokenStore() *memoryRevokedTokenStore {
+	return &memoryRevokedTokenStore{revoked: make(map[string]time.Time)}
+}
+
+func (s *memoryRevokedTokenStore) Revoke(_ context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	// Expired tokens are rejected anyway, forget them like the MongoDB TTL index does
+	for revokedJTI, expires := range s.revoked {
+		if now.After(expires) {
+			delete(s.revoked, revokedJTI)
+		}
+	}
+	if _, ok := s.revoked[jti]; !ok {
+		s.revoked[jti] = expiresAt
+	}
+	return nil
+}
+
+func (s *memoryRevokedTokenStore) IsRevoked(_ context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.revoked[jti]
+	return ok, nil
+}
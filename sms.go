@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// SMSSender отправляет SMS-сообщения на номер телефона.
+type SMSSender interface {
+	Send(ctx context.Context, phone, message string) error
+}
+
+// logSMSSender пишет сообщения в лог вместо отправки. Подходит для локальной разработки.
+type logSMSSender struct{}
+
+func (logSMSSender) Send(_ context.Context, phone, message string) error {
+	log.Printf("SMS to %s: %s", phone, message)
+	return nil
+}
+
+// fileSMSSender дописывает сообщения в файл, из которого их можно прочитать в тестах.
+type fileSMSSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+func (s *fileSMSSender) Send(_ context.Context, phone, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), phone, message)
+	return err
+}
+
+var smsSender SMSSender
+
+// initSMS выбирает способ отправки SMS по переменной SMS_SENDER: "log" (по умолчанию) или "file".
+// Для "file" путь к файлу задается через SMS_OUTBOX_FILE. Уведомления пользователям также уходят по SMS.
+func initSMS() {
+	switch config.SMSSender {
+	case "", "log":
+		smsSender = logSMSSender{}
+	case "file":
+		smsSender = &fileSMSSender{path: config.SMSOutboxFile}
+	default:
+		log.Fatalf("unknown SMS_SENDER %q", config.SMSSender)
+	}
+	notifier = smsNotifier{sender: smsSender}
+}
@@ -0,0 +1,729 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// storeBackends - реализации хранилищ, на которых прогоняются общие тесты. open возвращает пустые хранилища.
+var storeBackends = []struct {
+	name string
+	open func(t *testing.T) Stores
+}{
+	{"memory", func(*testing.T) Stores { return newMemoryStores() }},
+	{"bolt", openTestBoltStores},
+	{"postgres", openTestPostgresStores},
+}
+
+func openTestBoltStores(t *testing.T) Stores {
+	db, err := openBolt(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return newBoltStores(db)
+}
+
+// testPostgres - временный сервер PostgreSQL, который запускается при первом обращении и живет до конца тестов.
+var testPostgres struct {
+	once   sync.Once
+	server *embeddedpostgres.EmbeddedPostgres
+	dir    string
+	dsn    string
+	err    error
+}
+
+// testPostgresDSN возвращает адрес базы для тестов PostgreSQL: POSTGRES_TEST_DSN, если он задан,
+// иначе адрес временного сервера. Тесты очищают таблицы этой базы.
+func testPostgresDSN() (string, error) {
+	if dsn := os.Getenv("POSTGRES_TEST_DSN"); dsn != "" {
+		return dsn, nil
+	}
+	testPostgres.once.Do(startTestPostgres)
+	return testPostgres.dsn, testPostgres.err
+}
+
+// startTestPostgres запускает временный сервер PostgreSQL на свободном порту.
+// Бинарные файлы скачиваются при первом запуске и кешируются в ~/.embedded-postgres-go.
+func startTestPostgres() {
+	// initdb refuses to create a cluster owned by root
+	if os.Geteuid() == 0 {
+		testPostgres.err = errors.New("embedded PostgreSQL cannot run as root, run the tests as another user or set POSTGRES_TEST_DSN")
+		return
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		testPostgres.err = err
+		return
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	dir, err := os.MkdirTemp("", "auth-postgres-")
+	if err != nil {
+		testPostgres.err = err
+		return
+	}
+	testPostgres.dir = dir
+	postgresConfig := embeddedpostgres.DefaultConfig().
+		Port(uint32(port)).
+		Database("auth_test").
+		RuntimePath(dir).
+		Logger(io.Discard)
+	server := embeddedpostgres.NewDatabase(postgresConfig)
+	if err := server.Start(); err != nil {
+		testPostgres.err = fmt.Errorf("start embedded PostgreSQL: %w", err)
+		return
+	}
+	testPostgres.server = server
+	testPostgres.dsn = postgresConfig.GetConnectionURL() + "?sslmode=disable"
+}
+
+// stopTestPostgres останавливает временный сервер PostgreSQL, если он был запущен.
+func stopTestPostgres() {
+	if testPostgres.server != nil {
+		if err := testPostgres.server.Stop(); err != nil {
+			fmt.Fprintln(os.Stderr, "stop embedded PostgreSQL:", err)
+		}
+	}
+	if testPostgres.dir != "" {
+		os.RemoveAll(testPostgres.dir)
+	}
+}
+
+func openTestPostgresStores(t *testing.T) Stores {
+	dsn, err := testPostgresDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := context.Background()
+	if err := migratePostgres(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, `TRUNCATE users, posts, sessions, refresh_tokens, revoked_tokens, otp_codes,
+		login_attempts, rate_limits, api_keys, webauthn_credentials, webauthn_ceremonies, oauth_clients, oauth_codes`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := newPostgresStores(db)
+	stores.rateLimits = &postgresRateLimitStore{db: db}
+	return stores
+}
+
+// forEachStoreBackend запускает test на пустых хранилищах каждой реализации.
+func forEachStoreBackend(t *testing.T, test func(t *testing.T, stores Stores)) {
+	for _, backend := range storeBackends {
+		t.Run(backend.name, func(t *testing.T) {
+			test(t, backend.open(t))
+		})
+	}
+}
+
+// testTime возвращает текущее время с точностью, которую сохраняют все хранилища.
+func testTime() time.Time {
+	return time.Now().Truncate(time.Millisecond)
+}
+
+func TestUserStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		users := stores.users
+		alice := User{ID: primitive.NewObjectID(), Name: "Alice", Phone: "+77011234567", Role: RoleUser, Password: "hash"}
+		bob := User{ID: primitive.NewObjectID(), Name: "Bob", Phone: "+77011234568", Role: RoleUser}
+		for _, user := range []User{alice, bob} {
+			if err := users.Create(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := users.Create(ctx, User{ID: primitive.NewObjectID(), Phone: alice.Phone}); !errors.Is(err, errPhoneTaken) {
+			t.Errorf("duplicate phone: err = %v, want errPhoneTaken", err)
+		}
+
+		found, err := users.FindByPhone(ctx, alice.Phone)
+		if err != nil || found.ID != alice.ID || found.Name != "Alice" {
+			t.Errorf("FindByPhone = %+v, %v", found, err)
+		}
+		if _, err := users.FindByID(ctx, primitive.NewObjectID()); !errors.Is(err, errUserNotFound) {
+			t.Errorf("FindByID of a missing user: err = %v, want errUserNotFound", err)
+		}
+
+		all, total, err := users.List(ctx, "", 0, 0)
+		if err != nil || total != 2 || len(all) != 2 || all[0].ID != alice.ID {
+			t.Errorf("List without limit = %d users of %d, %v: want both in creation order", len(all), total, err)
+		}
+		page, total, err := users.List(ctx, "", 1, 1)
+		if err != nil || total != 2 || len(page) != 1 || page[0].ID != bob.ID {
+			t.Errorf("List(skip 1, limit 1) = %+v of %d, %v: want Bob", page, total, err)
+		}
+		found2, total, err := users.List(ctx, "bO", 0, 10)
+		if err != nil || total != 1 || len(found2) != 1 || found2[0].ID != bob.ID {
+			t.Errorf("List(search bO) = %+v of %d, %v: want Bob", found2, total, err)
+		}
+
+		name, role, mustChange := "Alice Smith", RoleModerator, true
+		updated, err := users.Update(ctx, alice.ID, UserUpdate{Name: &name, Role: &role, MustChangePassword: &mustChange, IncTokenVersion: true})
+		if err != nil || updated.Name != name || updated.Role != role || !updated.MustChangePassword || updated.TokenVersion != 1 {
+			t.Errorf("Update = %+v, %v", updated, err)
+		}
+		if _, err := users.Update(ctx, bob.ID, UserUpdate{Phone: &alice.Phone}); !errors.Is(err, errPhoneTaken) {
+			t.Errorf("Update to a taken phone: err = %v, want errPhoneTaken", err)
+		}
+
+		if ok, err := users.ReplacePassword(ctx, alice.ID, "stale", "new"); ok || err != nil {
+			t.Errorf("ReplacePassword with a stale hash = %v, %v: want false", ok, err)
+		}
+		if ok, err := users.ReplacePassword(ctx, alice.ID, "hash", "new"); !ok || err != nil {
+			t.Errorf("ReplacePassword = %v, %v: want true", ok, err)
+		}
+
+		if ok, _ := users.UseTOTPStep(ctx, alice.ID, 10); !ok {
+			t.Error("UseTOTPStep(10) rejected a new step")
+		}
+		if ok, _ := users.UseTOTPStep(ctx, alice.ID, 10); ok {
+			t.Error("UseTOTPStep(10) accepted the same step twice")
+		}
+
+		codes := []string{"a", "b"}
+		if _, err := users.Update(ctx, alice.ID, UserUpdate{RecoveryCodes: &codes}); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := users.UseRecoveryCode(ctx, alice.ID, "a"); !ok {
+			t.Error("UseRecoveryCode(a) rejected a stored code")
+		}
+		if ok, _ := users.UseRecoveryCode(ctx, alice.ID, "a"); ok {
+			t.Error("UseRecoveryCode(a) accepted a used code")
+		}
+
+		if err := users.Delete(ctx, bob.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := users.Delete(ctx, bob.ID); !errors.Is(err, errUserNotFound) {
+			t.Errorf("second Delete: err = %v, want errUserNotFound", err)
+		}
+	})
+}
+
+func TestPostStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		author := User{ID: primitive.NewObjectID(), Phone: "+77011234567"}
+		other := User{ID: primitive.NewObjectID(), Phone: "+77011234568"}
+		for _, user := range []User{author, other} {
+			if err := stores.users.Create(ctx, user); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		first := Post{ID: primitive.NewObjectID(), Title: "First", Date: testTime(), AuthorID: author.ID}
+		second := Post{ID: primitive.NewObjectID(), Title: "Second", Date: testTime(), AuthorID: other.ID}
+		for _, post := range []Post{first, second} {
+			if err := stores.posts.Create(ctx, post); err != nil {
+				t.Fatal(err)
+			}
+		}
+		posts, err := stores.posts.List(ctx)
+		if err != nil || len(posts) != 2 || posts[0].ID != first.ID || !posts[0].Date.Equal(first.Date) {
+			t.Fatalf("List = %+v, %v: want both posts in creation order", posts, err)
+		}
+
+		edit := Post{Title: "Edited", Date: testTime()}
+		if err := stores.posts.Update(ctx, first.ID, other.ID, edit); !errors.Is(err, errPostNotFound) {
+			t.Errorf("Update by another author: err = %v, want errPostNotFound", err)
+		}
+		if err := stores.posts.Update(ctx, first.ID, author.ID, edit); err != nil {
+			t.Errorf("Update by the author: %v", err)
+		}
+		if err := stores.posts.Update(ctx, second.ID, primitive.NilObjectID, edit); err != nil {
+			t.Errorf("Update without an author check: %v", err)
+		}
+
+		if err := stores.posts.Delete(ctx, first.ID, other.ID); !errors.Is(err, errPostNotFound) {
+			t.Errorf("Delete by another author: err = %v, want errPostNotFound", err)
+		}
+		if err := stores.posts.DeleteByAuthor(ctx, author.ID); err != nil {
+			t.Fatal(err)
+		}
+		posts, _ = stores.posts.List(ctx)
+		if len(posts) != 1 || posts[0].ID != second.ID || posts[0].Title != "Edited" {
+			t.Errorf("List after DeleteByAuthor = %+v: want only the edited second post", posts)
+		}
+	})
+}
+
+func TestSessionStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		sessions := stores.sessions
+		const phone = "+77011234567"
+		var ids []primitive.ObjectID
+		for i := 0; i < 3; i++ {
+			session := Session{ID: primitive.NewObjectID(), Phone: phone, Device: "phone", CreatedAt: testTime(), LastSeenAt: testTime()}
+			if err := sessions.Create(ctx, session); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, session.ID)
+		}
+
+		seen := testTime().Add(time.Minute)
+		if err := sessions.Touch(ctx, ids[0], phone, "10.0.0.1", seen); err != nil {
+			t.Fatal(err)
+		}
+		if err := sessions.Touch(ctx, ids[0], "+77019999999", "10.0.0.1", seen); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("Touch by another owner: err = %v, want errSessionNotFound", err)
+		}
+
+		if err := sessions.Revoke(ctx, ids[1], "+77019999999"); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("Revoke by another owner: err = %v, want errSessionNotFound", err)
+		}
+		if err := sessions.Revoke(ctx, ids[1], ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := sessions.Revoke(ctx, ids[1], ""); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("second Revoke: err = %v, want errSessionNotFound", err)
+		}
+		if err := sessions.Touch(ctx, ids[1], phone, "10.0.0.1", seen); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("Touch of a revoked session: err = %v, want errSessionNotFound", err)
+		}
+		if session, err := sessions.Find(ctx, ids[0], phone); err != nil || session.Device != "phone" || !session.LastSeenAt.Equal(seen) {
+			t.Errorf("Find = %+v, %v: want the touched session", session, err)
+		}
+		if _, err := sessions.Find(ctx, ids[0], "+77019999999"); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("Find by another owner: err = %v, want errSessionNotFound", err)
+		}
+		if _, err := sessions.Find(ctx, ids[1], phone); !errors.Is(err, errSessionNotFound) {
+			t.Errorf("Find of a revoked session: err = %v, want errSessionNotFound", err)
+		}
+
+		active, err := sessions.ListActive(ctx, phone)
+		if err != nil || len(active) != 2 || active[0].ID != ids[0] || active[1].ID != ids[2] {
+			t.Fatalf("ListActive = %+v, %v: want the first and the third session", active, err)
+		}
+		if active[0].IP != "10.0.0.1" || !active[0].LastSeenAt.Equal(seen) {
+			t.Errorf("touched session = %+v", active[0])
+		}
+
+		if err := sessions.RevokeAll(ctx, phone, ids[2]); err != nil {
+			t.Fatal(err)
+		}
+		if active, _ := sessions.ListActive(ctx, phone); len(active) != 1 || active[0].ID != ids[2] {
+			t.Errorf("ListActive after RevokeAll = %+v: want only the excluded session", active)
+		}
+
+		const newPhone = "+77011234568"
+		if err := sessions.ChangePhone(ctx, phone, newPhone); err != nil {
+			t.Fatal(err)
+		}
+		if active, _ := sessions.ListActive(ctx, newPhone); len(active) != 1 {
+			t.Errorf("ListActive for the new phone = %+v", active)
+		}
+		if err := sessions.RevokeAll(ctx, newPhone, primitive.NilObjectID); err != nil {
+			t.Fatal(err)
+		}
+		if active, _ := sessions.ListActive(ctx, newPhone); len(active) != 0 {
+			t.Errorf("ListActive after RevokeAll without exceptions = %+v", active)
+		}
+		if err := sessions.DeleteByPhone(ctx, newPhone); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestRefreshTokenStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		tokens := stores.refreshTokens
+		const phone = "+77011234567"
+		create := func(hash, family string) {
+			t.Helper()
+			token := RefreshToken{Hash: hash, Phone: phone, FamilyID: family, CreatedAt: testTime(), ExpiresAt: testTime().Add(time.Hour)}
+			if err := tokens.Create(ctx, token); err != nil {
+				t.Fatal(err)
+			}
+		}
+		create("a1", "a")
+		create("a2", "a")
+		create("b1", "b")
+
+		used, err := tokens.Use(ctx, "a1")
+		if err != nil || used.Used || used.FamilyID != "a" {
+			t.Errorf("Use = %+v, %v: want the unused token", used, err)
+		}
+		if _, err := tokens.Use(ctx, "a1"); !errors.Is(err, errRefreshTokenNotFound) {
+			t.Errorf("second Use: err = %v, want errRefreshTokenNotFound", err)
+		}
+		if found, err := tokens.Find(ctx, "a1"); err != nil || !found.Used {
+			t.Errorf("Find after Use = %+v, %v: want a used token", found, err)
+		}
+		if _, err := tokens.Find(ctx, "missing"); !errors.Is(err, errRefreshTokenNotFound) {
+			t.Errorf("Find of a missing token: err = %v, want errRefreshTokenNotFound", err)
+		}
+
+		if err := tokens.RevokeByPhone(ctx, phone, "b"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tokens.Use(ctx, "a2"); !errors.Is(err, errRefreshTokenNotFound) {
+			t.Errorf("Use of a revoked token: err = %v, want errRefreshTokenNotFound", err)
+		}
+		if found, _ := tokens.Find(ctx, "b1"); found.Revoked {
+			t.Error("RevokeByPhone revoked the excluded family")
+		}
+		if err := tokens.RevokeFamily(ctx, "b"); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := tokens.Find(ctx, "b1"); !found.Revoked {
+			t.Error("RevokeFamily left the token usable")
+		}
+
+		if err := tokens.ChangePhone(ctx, phone, "+77011234568"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tokens.DeleteByPhone(ctx, "+77011234568"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tokens.Find(ctx, "b1"); !errors.Is(err, errRefreshTokenNotFound) {
+			t.Errorf("Find after DeleteByPhone of the new phone: err = %v", err)
+		}
+	})
+}
+
+func TestRevokedTokenStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		if revoked, err := stores.revokedTokens.IsRevoked(ctx, "jti"); revoked || err != nil {
+			t.Errorf("IsRevoked before Revoke = %v, %v", revoked, err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := stores.revokedTokens.Revoke(ctx, "jti", testTime().Add(time.Hour)); err != nil {
+				t.Fatalf("Revoke #%d: %v", i+1, err)
+			}
+		}
+		if revoked, err := stores.revokedTokens.IsRevoked(ctx, "jti"); !revoked || err != nil {
+			t.Errorf("IsRevoked after Revoke = %v, %v", revoked, err)
+		}
+	})
+}
+
+func TestOTPStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		codes := stores.otpCodes
+		const phone = "+77011234567"
+		if _, err := codes.Find(ctx, phone, "login"); !errors.Is(err, errOTPNotFound) {
+			t.Errorf("Find before Save: err = %v, want errOTPNotFound", err)
+		}
+
+		for _, hash := range []string{"old", "new"} {
+			code := OTPCode{Phone: phone, Purpose: "login", CodeHash: hash, CreatedAt: testTime(), ExpiresAt: testTime().Add(time.Minute)}
+			if err := codes.Save(ctx, code); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := codes.IncAttempts(ctx, phone, "login"); err != nil {
+			t.Fatal(err)
+		}
+		code, err := codes.Find(ctx, phone, "login")
+		if err != nil || code.CodeHash != "new" || code.Attempts != 1 {
+			t.Errorf("Find = %+v, %v: want the replacing code with one attempt", code, err)
+		}
+		if _, err := codes.Find(ctx, phone, "reset"); !errors.Is(err, errOTPNotFound) {
+			t.Errorf("Find for another purpose: err = %v, want errOTPNotFound", err)
+		}
+
+		if deleted, err := codes.Delete(ctx, phone, "login", "old"); deleted || err != nil {
+			t.Errorf("Delete of a replaced code = %v, %v: want false", deleted, err)
+		}
+		if deleted, err := codes.Delete(ctx, phone, "login", "new"); !deleted || err != nil {
+			t.Errorf("Delete = %v, %v: want true", deleted, err)
+		}
+	})
+}
+
+func TestLoginAttemptStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		attempts := stores.loginAttempts
+		start := testTime()
+		expires := start.Add(time.Hour)
+
+		for i := 1; i <= 2; i++ {
+			attempt, err := attempts.RecordFailure(ctx, "phone:1", start.Add(-time.Minute), start, expires)
+			if err != nil || attempt.Failures != i {
+				t.Fatalf("RecordFailure #%d = %+v, %v", i, attempt, err)
+			}
+		}
+		// The previous failures are older than since, counting starts over
+		later := start.Add(10 * time.Minute)
+		attempt, err := attempts.RecordFailure(ctx, "phone:1", later.Add(-time.Minute), later, expires)
+		if err != nil || attempt.Failures != 1 || !attempt.LastFailure.Equal(later) {
+			t.Errorf("RecordFailure after the window = %+v, %v: want one failure", attempt, err)
+		}
+
+		if err := attempts.Lock(ctx, "phone:1", expires); err != nil {
+			t.Fatal(err)
+		}
+		found, err := attempts.Find(ctx, "phone:1", "ip:1")
+		if err != nil || len(found) != 1 || !found[0].LockedUntil.Equal(expires) {
+			t.Errorf("Find = %+v, %v: want the locked counter only", found, err)
+		}
+
+		if err := attempts.Reset(ctx, "phone:1"); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := attempts.Find(ctx, "phone:1"); len(found) != 0 {
+			t.Errorf("Find after Reset = %+v", found)
+		}
+	})
+}
+
+func TestRateLimitStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		limit := RateLimit{Requests: 2, Per: time.Hour}
+		for i := 0; i < 2; i++ {
+			if result, err := stores.rateLimits.Take(ctx, "key", limit); err != nil || !result.Allowed {
+				t.Fatalf("Take #%d = %+v, %v: want allowed", i+1, result, err)
+			}
+		}
+		result, err := stores.rateLimits.Take(ctx, "key", limit)
+		if err != nil || result.Allowed || result.RetryAfter <= 0 {
+			t.Errorf("Take over the limit = %+v, %v: want rejected with a retry delay", result, err)
+		}
+		if result, _ := stores.rateLimits.Take(ctx, "other", limit); !result.Allowed {
+			t.Error("a separate key shares the bucket")
+		}
+	})
+}
+
+func TestAPIKeyStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		keys := stores.apiKeys
+		owner, other := primitive.NewObjectID(), primitive.NewObjectID()
+		first := APIKey{ID: primitive.NewObjectID(), UserID: owner, Name: "ci", KeyHash: "h1", Scopes: []string{"posts:read"}, CreatedAt: testTime()}
+		second := APIKey{ID: primitive.NewObjectID(), UserID: owner, Name: "bot", KeyHash: "h2", Scopes: []string{"posts:write"}, CreatedAt: testTime()}
+		for _, key := range []APIKey{first, second} {
+			if err := keys.Create(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		found, err := keys.FindByHash(ctx, "h1")
+		if err != nil || found.ID != first.ID || len(found.Scopes) != 1 || !found.LastUsedAt.IsZero() {
+			t.Errorf("FindByHash = %+v, %v", found, err)
+		}
+		if _, err := keys.FindByHash(ctx, "missing"); !errors.Is(err, errAPIKeyNotFound) {
+			t.Errorf("FindByHash of a missing key: err = %v, want errAPIKeyNotFound", err)
+		}
+
+		if err := keys.Rename(ctx, first.ID, other, "stolen"); !errors.Is(err, errAPIKeyNotFound) {
+			t.Errorf("Rename by another user: err = %v, want errAPIKeyNotFound", err)
+		}
+		if err := keys.Rename(ctx, first.ID, owner, "deploy"); err != nil {
+			t.Fatal(err)
+		}
+		used := testTime()
+		if err := keys.Touch(ctx, first.ID, used); err != nil {
+			t.Fatal(err)
+		}
+		list, err := keys.ListByUser(ctx, owner)
+		if err != nil || len(list) != 2 || list[0].ID != first.ID || list[0].Name != "deploy" || !list[0].LastUsedAt.Equal(used) {
+			t.Errorf("ListByUser = %+v, %v", list, err)
+		}
+
+		if err := keys.Delete(ctx, second.ID, other); !errors.Is(err, errAPIKeyNotFound) {
+			t.Errorf("Delete by another user: err = %v, want errAPIKeyNotFound", err)
+		}
+		if err := keys.DeleteByUser(ctx, owner); err != nil {
+			t.Fatal(err)
+		}
+		if list, _ := keys.ListByUser(ctx, owner); len(list) != 0 {
+			t.Errorf("ListByUser after DeleteByUser = %+v", list)
+		}
+	})
+}
+
+func TestWebAuthnCredentialStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		credentials := stores.webAuthnCredentials
+		owner := primitive.NewObjectID()
+		credential := WebAuthnCredential{
+			ID:           primitive.NewObjectID(),
+			UserID:       owner,
+			Name:         "Phone",
+			CredentialID: []byte{1, 2, 3},
+			PublicKey:    []byte{4, 5, 6},
+			Transports:   []string{"internal"},
+			SignCount:    1,
+			CreatedAt:    testTime(),
+		}
+		if err := credentials.Create(ctx, credential); err != nil {
+			t.Fatal(err)
+		}
+		duplicate := credential
+		duplicate.ID = primitive.NewObjectID()
+		if err := credentials.Create(ctx, duplicate); !errors.Is(err, errWebAuthnCredentialExists) {
+			t.Errorf("Create with a registered credential ID: err = %v, want errWebAuthnCredentialExists", err)
+		}
+
+		used := testTime()
+		if err := credentials.RecordUse(ctx, credential.CredentialID, 7, true, used); err != nil {
+			t.Fatal(err)
+		}
+		list, err := credentials.ListByUser(ctx, owner)
+		if err != nil || len(list) != 1 {
+			t.Fatalf("ListByUser = %+v, %v", list, err)
+		}
+		if got := list[0]; got.SignCount != 7 || !got.BackupState || !got.LastUsedAt.Equal(used) || string(got.PublicKey) != string(credential.PublicKey) {
+			t.Errorf("credential after RecordUse = %+v", got)
+		}
+
+		if err := credentials.Delete(ctx, credential.ID, primitive.NewObjectID()); !errors.Is(err, errWebAuthnCredentialNotFound) {
+			t.Errorf("Delete by another user: err = %v, want errWebAuthnCredentialNotFound", err)
+		}
+		if err := credentials.Delete(ctx, credential.ID, owner); err != nil {
+			t.Fatal(err)
+		}
+		if err := credentials.DeleteByUser(ctx, owner); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestWebAuthnCeremonyStore(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		ceremonies := stores.webAuthnCeremonies
+		now := testTime()
+		userID := primitive.NewObjectID()
+		for _, ceremony := range []WebAuthnCeremony{
+			{Challenge: "registration", Purpose: "registration", UserID: userID, Session: []byte("{}"), ExpiresAt: now.Add(time.Minute)},
+			{Challenge: "login", Purpose: "login", Session: []byte("{}"), ExpiresAt: now.Add(time.Minute)},
+			{Challenge: "expired", Purpose: "login", Session: []byte("{}"), ExpiresAt: now.Add(-time.Second)},
+		} {
+			if err := ceremonies.Save(ctx, ceremony); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := ceremonies.Take(ctx, "login", "registration", now); !errors.Is(err, errWebAuthnCeremonyNotFound) {
+			t.Errorf("Take for another purpose: err = %v, want errWebAuthnCeremonyNotFound", err)
+		}
+		ceremony, err := ceremonies.Take(ctx, "registration", "registration", now)
+		if err != nil || ceremony.UserID != userID || string(ceremony.Session) != "{}" {
+			t.Errorf("Take = %+v, %v", ceremony, err)
+		}
+		if _, err := ceremonies.Take(ctx, "registration", "registration", now); !errors.Is(err, errWebAuthnCeremonyNotFound) {
+			t.Errorf("second Take: err = %v, want errWebAuthnCeremonyNotFound", err)
+		}
+		if ceremony, err := ceremonies.Take(ctx, "login", "login", now); err != nil || !ceremony.UserID.IsZero() {
+			t.Errorf("Take of a discoverable login = %+v, %v: want no user", ceremony, err)
+		}
+		if _, err := ceremonies.Take(ctx, "login", "expired", now); !errors.Is(err, errWebAuthnCeremonyNotFound) {
+			t.Errorf("Take of an expired ceremony: err = %v, want errWebAuthnCeremonyNotFound", err)
+		}
+	})
+}
+
+func TestOAuthStores(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		client := OAuthClient{
+			ID:           primitive.NewObjectID(),
+			ClientID:     "client",
+			Name:         "App",
+			RedirectURIs: []string{"https://app.example/callback"},
+			GrantTypes:   []string{"authorization_code"},
+			Confidential: true,
+			CreatedAt:    testTime(),
+		}
+		if err := stores.oauthClients.Create(ctx, client); err != nil {
+			t.Fatal(err)
+		}
+		found, err := stores.oauthClients.FindByClientID(ctx, "client")
+		if err != nil || found.ID != client.ID || !found.Confidential || len(found.RedirectURIs) != 1 {
+			t.Errorf("FindByClientID = %+v, %v", found, err)
+		}
+		if _, err := stores.oauthClients.FindByClientID(ctx, "missing"); !errors.Is(err, errInvalidClient) {
+			t.Errorf("FindByClientID of a missing client: err = %v, want errInvalidClient", err)
+		}
+
+		code := AuthorizationCode{
+			Hash:      "code",
+			ClientID:  "client",
+			UserID:    primitive.NewObjectID(),
+			Scope:     "openid",
+			AuthTime:  testTime(),
+			ExpiresAt: testTime().Add(time.Minute),
+		}
+		if err := stores.oauthCodes.Create(ctx, code); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stores.oauthCodes.Use(ctx, "code", "other"); !errors.Is(err, errInvalidGrant) {
+			t.Errorf("Use by another client: err = %v, want errInvalidGrant", err)
+		}
+		used, err := stores.oauthCodes.Use(ctx, "code", "client")
+		if err != nil || used.Used || used.UserID != code.UserID || used.Scope != "openid" {
+			t.Errorf("Use = %+v, %v: want the unused code", used, err)
+		}
+		if _, err := stores.oauthCodes.Use(ctx, "code", "client"); !errors.Is(err, errInvalidGrant) {
+			t.Errorf("second Use: err = %v, want errInvalidGrant", err)
+		}
+	})
+}
+
+func TestPurgeExpiredBolt(t *testing.T) {
+	db, err := openBolt(filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stores := newBoltStores(db)
+	ctx := context.Background()
+	now := testTime()
+
+	for jti, expiresAt := range map[string]time.Time{"expired": now.Add(-time.Second), "live": now.Add(time.Hour)} {
+		if err := stores.revokedTokens.Revoke(ctx, jti, expiresAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idle := Session{ID: primitive.NewObjectID(), Phone: "+77011234567", LastSeenAt: now.Add(-refreshTokenTTL - time.Minute)}
+	recent := Session{ID: primitive.NewObjectID(), Phone: idle.Phone, LastSeenAt: now.Add(-time.Minute)}
+	for _, session := range []Session{idle, recent} {
+		if err := stores.sessions.Create(ctx, session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := purgeExpiredBolt(db, now); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, _ := stores.revokedTokens.IsRevoked(ctx, "expired"); revoked {
+		t.Error("expired revoked token survived the purge")
+	}
+	if revoked, _ := stores.revokedTokens.IsRevoked(ctx, "live"); !revoked {
+		t.Error("live revoked token was purged")
+	}
+	if active, _ := stores.sessions.ListActive(ctx, idle.Phone); len(active) != 1 || active[0].ID != recent.ID {
+		t.Errorf("sessions after purge = %+v: want only the recent one", active)
+	}
+}
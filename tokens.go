@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+	// accessTokenAudience отличает access-токены сервиса от токенов MFA и токенов OAuth-клиентов,
+	// подписанных тем же ключом
+	accessTokenAudience = "access"
+)
+
+var (
+	errRefreshTokenInvalid = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// issueRefreshToken создает новый refresh-токен в указанном семействе и сохраняет его хеш.
+// Семейство совпадает с идентификатором сессии, открытой при входе пользователя.
+func (h *Handler) issueRefreshToken(ctx context.Context, phone, familyID string) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = h.refreshTokens.Create(ctx, RefreshToken{
+		Hash:      hashToken(token),
+		Phone:     phone,
+		FamilyID:  familyID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// rotateRefreshToken помечает предъявленный refresh-токен использованным и выдает новый из того же семейства.
+// Повторное предъявление уже использованного токена отзывает все семейство.
+func (h *Handler) rotateRefreshToken(ctx context.Context, token string) (RefreshToken, string, error) {
+	hash := hashToken(token)
+
+	current, err := h.refreshTokens.Use(ctx, hash)
+	if errors.Is(err, errRefreshTokenNotFound) {
+		stale, err := h.refreshTokens.Find(ctx, hash)
+		if err != nil {
+			return RefreshToken{}, "", errRefreshTokenInvalid
+		}
+		if stale.Used {
+			if err := h.revokeRefreshTokenFamily(ctx, stale.FamilyID); err != nil {
+				return RefreshToken{}, "", err
+			}
+			return RefreshToken{}, "", errRefreshTokenReused
+		}
+		return RefreshToken{}, "", errRefreshTokenInvalid
+	}
+	if err != nil {
+		return RefreshToken{}, "", err
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		return RefreshToken{}, "", errRefreshTokenInvalid
+	}
+
+	next, err := h.issueRefreshToken(ctx, current.Phone, current.FamilyID)
+	if err != nil {
+		return RefreshToken{}, "", err
+	}
+	return current, next, nil
+}
+
+// revokeRefreshTokenFamily отзывает все refresh-токены семейства вместе с соответствующей сессией.
+func (h *Handler) revokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	if err := h.refreshTokens.RevokeFamily(ctx, familyID); err != nil {
+		return err
+	}
+	return h.revokeSession(ctx, familyID)
+}
+
+// revokeRefreshToken отзывает семейство, к которому принадлежит предъявленный refresh-токен.
+func (h *Handler) revokeRefreshToken(ctx context.Context, token string) error {
+	stored, err := h.refreshTokens.Find(ctx, hashToken(token))
+	if errors.Is(err, errRefreshTokenNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return h.revokeRefreshTokenFamily(ctx, stored.FamilyID)
+}
+
+// respondWithTokens открывает новую сессию пользователя и отвечает парой access- и refresh-токенов.
+// Используется всеми способами входа, чтобы клиенты получали одинаковый ответ.
+func (h *Handler) respondWithTokens(c *gin.Context, user User) {
+	session, err := h.createSession(c, user.Phone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating session"})
+		return
+	}
+	token, err := GenerateJWT(user, session.ID.Hex())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+	refreshToken, err := h.issueRefreshToken(context.Background(), user.Phone, session.ID.Hex())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+		return
+	}
+
+	if cookieModeRequested(c) {
+		csrfToken, err := setAuthCookies(c, token, refreshToken)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error generating token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"csrf_token": csrfToken, "userData": userSummary(user)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken, "userData": userSummary(user)})
+}
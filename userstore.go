@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errUserNotFound = errors.New("user not found")
+	errPhoneTaken   = errors.New("phone is already registered")
+)
+
+// UserStore хранит пользователей. Методы возвращают errUserNotFound, если пользователя нет,
+// и errPhoneTaken, если телефон уже занят другим пользователем.
+type UserStore interface {
+	Create(ctx context.Context, user User) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (User, error)
+	FindByPhone(ctx context.Context, phone string) (User, error)
+	// List возвращает страницу пользователей в порядке создания и общее число найденных.
+	// search ищет подстроку в телефоне или имени без учета регистра, нулевой limit возвращает всех.
+	List(ctx context.Context, search string, skip, limit int64) ([]User, int64, error)
+	// Update применяет изменение и возвращает обновленного пользователя.
+	Update(ctx context.Context, id primitive.ObjectID, update UserUpdate) (User, error)
+	// ReplacePassword меняет хеш пароля, только если текущий хеш равен current.
+	ReplacePassword(ctx context.Context, id primitive.ObjectID, current, hash string) (bool, error)
+	// UseTOTPStep запоминает период TOTP-кода, только если он новее последнего принятого.
+	UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error)
+	// UseRecoveryCode удаляет хеш кода восстановления, если он есть у пользователя.
+	UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) (bool, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+// UserUpdate описывает изменение пользователя: поля со значением nil не меняются.
+// Пустые строки и срезы в TOTP-полях удаляют соответствующие данные.
+type UserUpdate struct {
+	Name               *string
+	Phone              *string
+	PhoneVerified      *bool
+	Password           *string
+	Role               *string
+	Disabled           *bool
+	MustChangePassword *bool
+	TOTPEnabled        *bool
+	TOTPSecret         *string
+	TOTPPendingURL     *string
+	TOTPLastStep       *int64
+	RecoveryCodes      *[]string
+	IncTokenVersion    bool
+}
+
+// apply применяет изменение к пользователю в памяти.
+func (u UserUpdate) apply(user *User) {
+	if u.Name != nil {
+		user.Name = *u.Name
+	}
+	if u.Phone != nil {
+		user.Phone = *u.Phone
+	}
+	if u.PhoneVerified != nil {
+		user.PhoneVerified = *u.PhoneVerified
+	}
+	if u.Password != nil {
+		user.Password = *u.Password
+	}
+	if u.Role != nil {
+		user.Role = *u.Role
+	}
+	if u.Disabled != nil {
+		user.Disabled = *u.Disabled
+	}
+	if u.MustChangePassword != nil {
+		user.MustChangePassword = *u.MustChangePassword
+	}
+	if u.TOTPEnabled != nil {
+		user.TOTPEnabled = *u.TOTPEnabled
+	}
+	if u.TOTPSecret != nil {
+		user.TOTPSecret = *u.TOTPSecret
+	}
+	if u.TOTPPendingURL != nil {
+		user.TOTPPendingURL = *u.TOTPPendingURL
+	}
+	if u.TOTPLastStep != nil {
+		user.TOTPLastStep = *u.TOTPLastStep
+	}
+	if u.RecoveryCodes != nil {
+		user.RecoveryCodes = append([]string(nil), (*u.RecoveryCodes)...)
+	}
+	if u.IncTokenVersion {
+		user.TokenVersion++
+	}
+}
+
+// mongoUserStore хранит пользователей в коллекции MongoDB.
+type mongoUserStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoUserStore) Create(ctx context.Context, user User) error {
+	_, err := s.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return errPhoneTaken
+	}
+	return err
+}
+
+func (s *mongoUserStore) findOne(ctx context.Context, filter bson.M) (User, error) {
+	var user User
+	err := s.collection.FindOne(ctx, filter).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return User{}, errUserNotFound
+	}
+	return user, err
+}
+
+func (s *mongoUserStore) FindByID(ctx context.Context, id primitive.ObjectID) (User, error) {
+	return s.findOne(ctx, bson.M{"_id": id})
+}
+
+func (s *mongoUserStore) FindByPhone(ctx context.Context, phone string) (User, error) {
+	return s.findOne(ctx, bson.M{"phone": phone})
+}
+
+func (s *mongoUserStore) List(ctx context.Context, search string, skip, limit int64) ([]User, int64, error) {
+	filter := bson.M{}
+	if search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		filter["$or"] = bson.A{bson.M{"phone": pattern}, bson.M{"name": pattern}}
+	}
+
+	total, err := s.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+	cursor, err := s.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	users := []User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (s *mongoUserStore) Update(ctx context.Context, id primitive.ObjectID, u UserUpdate) (User, error) {
+	set, unset := bson.M{}, bson.M{}
+	setOrUnset := func(key string, value interface{}, empty bool) {
+		if empty {
+			unset[key] = ""
+		} else {
+			set[key] = value
+		}
+	}
+	if u.Name != nil {
+		set["name"] = *u.Name
+	}
+	if u.Phone != nil {
+		set["phone"] = *u.Phone
+	}
+	if u.PhoneVerified != nil {
+		set["phone_verified"] = *u.PhoneVerified
+	}
+	if u.Password != nil {
+		set["password"] = *u.Password
+	}
+	if u.Role != nil {
+		set["role"] = *u.Role
+	}
+	if u.Disabled != nil {
+		set["disabled"] = *u.Disabled
+	}
+	if u.MustChangePassword != nil {
+		set["must_change_password"] = *u.MustChangePassword
+	}
+	if u.TOTPEnabled != nil {
+		set["totp_enabled"] = *u.TOTPEnabled
+	}
+	if u.TOTPSecret != nil {
+		setOrUnset("totp_secret", *u.TOTPSecret, *u.TOTPSecret == "")
+	}
+	if u.TOTPPendingURL != nil {
+		setOrUnset("totp_pending_url", *u.TOTPPendingURL, *u.TOTPPendingURL == "")
+	}
+	if u.TOTPLastStep != nil {
+		setOrUnset("totp_last_step", *u.TOTPLastStep, *u.TOTPLastStep == 0)
+	}
+	if u.RecoveryCodes != nil {
+		setOrUnset("recovery_codes", *u.RecoveryCodes, len(*u.RecoveryCodes) == 0)
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if u.IncTokenVersion {
+		update["$inc"] = bson.M{"token_version": 1}
+	}
+	if len(update) == 0 {
+		return s.FindByID(ctx, id)
+	}
+
+	var user User
+	err := s.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": id},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return User{}, errUserNotFound
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return User{}, errPhoneTaken
+	}
+	return user, err
+}
+
+// updateIf выполняет обновление, только если пользователь подходит под условие, и сообщает, было ли оно применено.
+func (s *mongoUserStore) updateIf(ctx context.Context, filter, update bson.M) (bool, error) {
+	result, err := s.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+func (s *mongoUserStore) ReplacePassword(ctx context.Context, id primitive.ObjectID, current, hash string) (bool, error) {
+	return s.updateIf(ctx,
+		bson.M{"_id": id, "password": current},
+		bson.M{"$set": bson.M{"password": hash}},
+	)
+}
+
+func (s *mongoUserStore) UseTOTPStep(ctx context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	return s.updateIf(ctx,
+		bson.M{"_id": id, "totp_last_step": bson.M{"$not": bson.M{"$gte": step}}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+}
+
+func (s *mongoUserStore) UseRecoveryCode(ctx context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	return s.updateIf(ctx,
+		bson.M{"_id": id, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+}
+
+func (s *mongoUserStore) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errUserNotFound
+	}
+	return nil
+}
+
+// memoryUserStore хранит пользователей в памяти процесса. Подходит для тестов и локальной разработки.
+type memoryUserStore struct {
+	mu    sync.Mutex
+	users map[primitive.ObjectID]User
+}
+
+func newMemoryUserStore() *memoryUserStore {
+	return &memoryUserStore{users: make(map[primitive.ObjectID]User)}
+}
+
+// phoneTaken проверяет, занят ли телефон другим пользователем. Вызывается под блокировкой.
+func (s *memoryUserStore) phoneTaken(phone string, except primitive.ObjectID) bool {
+	for id, user := range s.users {
+		if user.Phone == phone && id != except {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryUserStore) Create(_ context.Context, user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user.ID.IsZero() {
+		user.ID = primitive.NewObjectID()
+	}
+	if s.phoneTaken(user.Phone, user.ID) {
+		return errPhoneTaken
+	}
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *memoryUserStore) FindByID(_ context.Context, id primitive.ObjectID) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	return user, nil
+}
+
+func (s *memoryUserStore) FindByPhone(_ context.Context, phone string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Phone == phone {
+			return user, nil
+		}
+	}
+	return User{}, errUserNotFound
+}
+
+func (s *memoryUserStore) List(_ context.Context, search string, skip, limit int64) ([]User, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	search = strings.ToLower(search)
+	matched := []User{}
+	for _, user := range s.users {
+		if search == "" || strings.Contains(strings.ToLower(user.Phone), search) || strings.Contains(strings.ToLower(user.Name), search) {
+			matched = append(matched, user)
+		}
+	}
+	// ObjectIDs grow with creation time, same order as the MongoDB store
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].ID.Hex() < matched[j].ID.Hex()
+	})
+
+	total := int64(len(matched))
+	if skip > total {
+		skip = total
+	}
+	end := total
+	if limit > 0 && skip+limit < total {
+		end = skip + limit
+	}
+	return matched[skip:end], total, nil
+}
+
+func (s *memoryUserStore) Update(_ context.Context, id primitive.ObjectID, u UserUpdate) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	if u.Phone != nil && s.phoneTaken(*u.Phone, id) {
+		return User{}, errPhoneTaken
+	}
+	u.apply(&user)
+	s.users[id] = user
+	return user, nil
+}
+
+// updateIf применяет изменение, если пользователь подходит под условие.
+func (s *memoryUserStore) updateIf(id primitive.ObjectID, match func(User) bool, u UserUpdate) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || !match(user) {
+		return false, nil
+	}
+	u.apply(&user)
+	s.users[id] = user
+	return true, nil
+}
+
+func (s *memoryUserStore) ReplacePassword(_ context.Context, id primitive.ObjectID, current, hash string) (bool, error) {
+	return s.updateIf(id,
+		func(user User) bool { return user.Password == current },
+		UserUpdate{Password: &hash},
+	)
+}
+
+func (s *memoryUserStore) UseTOTPStep(_ context.Context, id primitive.ObjectID, step int64) (bool, error) {
+	return s.updateIf(id,
+		func(user User) bool { return user.TOTPLastStep < step },
+		UserUpdate{TOTPLastStep: &step},
+	)
+}
+
+func (s *memoryUserStore) UseRecoveryCode(_ context.Context, id primitive.ObjectID, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return false, nil
+	}
+	for i, code := range user.RecoveryCodes {
+		if code == hash {
+			user.RecoveryCodes = append(append([]string(nil), user.RecoveryCodes[:i]...), user.RecoveryCodes[i+1:]...)
+			s.users[id] = user
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryUserStore) Delete(_ context.Context, id primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return errUserNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
@@ -1,50 +1,90 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
-	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtKey = []byte("your_secret_key")
-
 type Claims struct {
-	Phone string `json:"phone"`
+	Phone        string `json:"phone"`
+	Role         string `json:"role"`
+	SessionID    string `json:"sid"`
+	TokenVersion int    `json:"ver"`
 	jwt.StandardClaims
 }
 
-// HashPassword хеширует пароль с использованием bcrypt.
+// HashPassword хеширует пароль текущим алгоритмом (argon2id или bcrypt).
 // @param password string Исходный пароль для хеширования.
-// @return string Захешированный пароль.
+// @return string Захешированный пароль в формате PHC.
 // @return error Ошибка, если произошла ошибка хеширования.
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	return passwordHasher.Hash(password)
 }
 
-// CheckPasswordHash проверяет соответствие пароля и его хеша с использованием bcrypt.
+// CheckPasswordHash проверяет соответствие пароля и его хеша, определяя алгоритм по формату хеша.
 // @param password string Исходный пароль для проверки.
 // @param hash string Захешированный пароль для сравнения.
 // @return bool Результат проверки: true - если пароль совпадает с хешем, false - в противном случае.
 func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	h, err := hasherFor(hash)
+	if err != nil {
+		return false
+	}
+	ok, err := h.Verify(password, hash)
+	return err == nil && ok
 }
 
-// GenerateJWT генерирует JWT на основе телефонного номера.
-// @param phone string Телефонный номер пользователя.
+// GenerateJWT генерирует короткоживущий access-токен для пользователя в рамках сессии.
+// @param user User Пользователь, для которого выпускается токен.
+// @param sessionID string Идентификатор сессии.
 // @return string Сгенерированный JWT токен.
 // @return error Ошибка, если не удалось сгенерировать токен.
-func GenerateJWT(phone string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+func GenerateJWT(user User, sessionID string) (string, error) {
+	jti, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	role := user.Role
+	if role == "" {
+		role = RoleUser
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(accessTokenTTL)
 	claims := &Claims{
-		Phone: phone,
+		Phone:        user.Phone,
+		Role:         role,
+		SessionID:    sessionID,
+		TokenVersion: user.TokenVersion,
 		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   user.ID.Hex(),
+			Audience:  accessTokenAudience,
+			IssuedAt:  now.Unix(),
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return jwtKeys.sign(claims)
+}
+
+// generateOpaqueToken генерирует случайный непрозрачный токен в base64url.
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken возвращает SHA-256 хеш токена для хранения в базе.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
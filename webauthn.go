@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	webAuthnCeremonyTTL = 5 * time.Minute
+
+	webAuthnPurposeRegister = "register"
+	webAuthnPurposeLogin    = "login"
+
+	defaultPasskeyName = "Passkey"
+)
+
+var (
+	errWebAuthnCeremonyNotFound = errors.New("webauthn ceremony not found")
+	errWebAuthnCredentialCloned = errors.New("webauthn credential may be cloned")
+)
+
+var webAuthn *webauthn.WebAuthn
+
+func initWebAuthn() {
+	var err error
+	webAuthn, err = webauthn.New(&webauthn.Config{
+		RPID:          config.WebAuthnRPID,
+		RPDisplayName: config.WebAuthnRPName,
+		RPOrigins:     config.WebAuthnRPOrigins,
+	})
+	if err != nil {
+		log.Fatalf("invalid WebAuthn configuration: %v", err)
+	}
+}
+
+// webAuthnUser связывает пользователя с его ключами для библиотеки WebAuthn.
+type webAuthnUser struct {
+	user        User
+	credentials []WebAuthnCredential
+}
+
+func (u webAuthnUser) WebAuthnID() []byte {
+	return []byte(u.user.ID.Hex())
+}
+
+func (u webAuthnUser) WebAuthnName() string {
+	return u.user.Phone
+}
+
+func (u webAuthnUser) WebAuthnDisplayName() string {
+	if u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.user.Phone
+}
+
+func (u webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, stored := range u.credentials {
+		credentials = append(credentials, stored.credential())
+	}
+	return credentials
+}
+
+// credential преобразует сохраненный ключ в формат библиотеки WebAuthn.
+func (stored WebAuthnCredential) credential() webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, 0, len(stored.Transports))
+	for _, transport := range stored.Transports {
+		transports = append(transports, protocol.AuthenticatorTransport(transport))
+	}
+	return webauthn.Credential{
+		ID:              stored.CredentialID,
+		PublicKey:       stored.PublicKey,
+		AttestationType: stored.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: stored.BackupEligible,
+			BackupState:    stored.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:     stored.AAGUID,
+			SignCount:  stored.SignCount,
+			Attachment: protocol.AuthenticatorAttachment(stored.Attachment),
+		},
+	}
+}
+
+// loadWebAuthnUserByID загружает пользователя по идентификатору вместе с его ключами.
+func (h *Handler) loadWebAuthnUserByID(ctx context.Context, id primitive.ObjectID) (webAuthnUser, error) {
+	user, err := h.users.FindByID(ctx, id)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	return h.loadWebAuthnUser(ctx, user)
+}
+
+// loadWebAuthnUser загружает ключи пользователя.
+func (h *Handler) loadWebAuthnUser(ctx context.Context, user User) (webAuthnUser, error) {
+	credentials, err := h.webAuthnCredentials.ListByUser(ctx, user.ID)
+	if err != nil {
+		return webAuthnUser{}, err
+	}
+	return webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+// saveWebAuthnCeremony сохраняет состояние начатой церемонии до ее завершения.
+func (h *Handler) saveWebAuthnCeremony(ctx context.Context, purpose string, userID primitive.ObjectID, session *webauthn.SessionData) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return h.webAuthnCeremonies.Save(ctx, WebAuthnCeremony{
+		Challenge: session.Challenge,
+		Purpose:   purpose,
+		UserID:    userID,
+		Session:   data,
+		ExpiresAt: time.Now().Add(webAuthnCeremonyTTL),
+	})
+}
+
+// takeWebAuthnCeremony находит и удаляет церемонию по challenge из ответа клиента,
+// поэтому каждый challenge может быть использован только один раз.
+func (h *Handler) takeWebAuthnCeremony(ctx context.Context, purpose, challenge string) (WebAuthnCeremony, webauthn.SessionData, error) {
+	ceremony, err := h.webAuthnCeremonies.Take(ctx, purpose, challenge, time.Now())
+	if err != nil {
+		return WebAuthnCeremony{}, webauthn.SessionData{}, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(ceremony.Session, &session); err != nil {
+		return WebAuthnCeremony{}, webauthn.SessionData{}, err
+	}
+	return ceremony, session, nil
+}
+
+// recordWebAuthnUse сохраняет счетчик подписей и флаги ключа после успешного входа.
+// Счетчик, который не вырос, означает, что ключ мог быть скопирован.
+func (h *Handler) recordWebAuthnUse(ctx context.Context, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return errWebAuthnCredentialCloned
+	}
+	return h.webAuthnCredentials.RecordUse(ctx, credential.ID,
+		credential.Authenticator.SignCount, credential.Flags.BackupState, time.Now())
+}
+
+// BeginWebAuthnRegistration godoc
+//
+//	@Summary		Start passkey registration
+//	@Description	Return credential creation options for navigator.credentials.create(). Pass the result to FinishWebAuthnRegistration.
+//	@Tags			WebAuthn
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{object}	map[string]interface{}	"Credential creation options"
+//	@Failure		500	{object}	map[string]string		"Failed to start registration"
+//	@Router			/protected/webauthn/register/begin [post]
+func (h *Handler) BeginWebAuthnRegistration(c *gin.Context) {
+	user, err := h.loadWebAuthnUser(context.Background(), currentUser(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	// Do not let the same authenticator be registered twice
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.credentials))
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, session, err := webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(exclusions),
+		// Login is always discoverable, a passkey that is not stored on the authenticator could not be used
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start registration"})
+		return
+	}
+	if err := h.saveWebAuthnCeremony(context.Background(), webAuthnPurposeRegister, user.user.ID, session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, creation)
+}
+
+// FinishWebAuthnRegistration godoc
+//
+//	@Summary		Finish passkey registration
+//	@Description	Verify the attestation returned by navigator.credentials.create() and store the new passkey
+//	@Tags			WebAuthn
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			name		query		string				false	"Passkey name shown in the credential list"
+//	@Param			credential	body		object				true	"PublicKeyCredential returned by the browser"
+//	@Success		201			{object}	WebAuthnCredential	"Registered passkey"
+//	@Failure		400			{object}	map[string]string	"Invalid credential or expired challenge"
+//	@Failure		409			{object}	map[string]string	"Passkey is already registered"
+//	@Failure		500			{object}	map[string]string	"Failed to register passkey"
+//	@Router			/protected/webauthn/register/finish [post]
+func (h *Handler) FinishWebAuthnRegistration(c *gin.Context) {
+	parsed, err := protocol.ParseCredentialCreationResponseBody(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential"})
+		return
+	}
+
+	user, err := h.loadWebAuthnUser(context.Background(), currentUser(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return
+	}
+
+	ceremony, session, err := h.takeWebAuthnCeremony(context.Background(), webAuthnPurposeRegister, parsed.Response.CollectedClientData.Challenge)
+	if err != nil && !errors.Is(err, errWebAuthnCeremonyNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register passkey"})
+		return
+	}
+	// The challenge must have been issued to the same user
+	if err != nil || ceremony.UserID != user.user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired challenge"})
+		return
+	}
+
+	credential, err := webAuthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential"})
+		return
+	}
+
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+	stored := WebAuthnCredential{
+		ID:              primitive.NewObjectID(),
+		UserID:          user.user.ID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Attachment:      string(credential.Authenticator.Attachment),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+	err = h.webAuthnCredentials.Create(context.Background(), stored)
+	if errors.Is(err, errWebAuthnCredentialExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Passkey is already registered"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register passkey"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, stored)
+}
+
+// ListWebAuthnCredentials godoc
+//
+//	@Summary		List passkeys
+//	@Description	List passkeys registered by the authenticated user
+//	@Tags			WebAuthn
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{array}		WebAuthnCredential	"List of passkeys"
+//	@Failure		500	{object}	map[string]string	"Failed to fetch passkeys"
+//	@Router			/protected/webauthn/credentials [get]
+func (h *Handler) ListWebAuthnCredentials(c *gin.Context) {
+	user, err := h.loadWebAuthnUser(context.Background(), currentUser(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch passkeys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, user.credentials)
+}
+
+// DeleteWebAuthnCredential godoc
+//
+//	@Summary		Remove a passkey
+//	@Description	Remove one of the authenticated user's passkeys
+//	@Tags			WebAuthn
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string				true	"Passkey ID"
+//	@Success		200	{object}	map[string]string	"Passkey removed"
+//	@Failure		400	{object}	map[string]string	"Invalid passkey ID"
+//	@Failure		404	{object}	map[string]string	"Passkey not found"
+//	@Failure		500	{object}	map[string]string	"Failed to remove passkey"
+//	@Router			/protected/webauthn/credentials/{id} [delete]
+func (h *Handler) DeleteWebAuthnCredential(c *gin.Context) {
+	objID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey ID"})
+		return
+	}
+
+	// Ensure the passkey belongs to the authenticated user
+	err = h.webAuthnCredentials.Delete(context.Background(), objID, currentUserID(c))
+	if errors.Is(err, errWebAuthnCredentialNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove passkey"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey removed successfully"})
+}
+
+// BeginWebAuthnLogin godoc
+//
+//	@Summary		Start passkey login
+//	@Description	Return credential request options for navigator.credentials.get() that allow any discoverable passkey. The options are the same for every caller, so they do not reveal which phones have passkeys.
+//	@Tags			WebAuthn
+//	@Produce		json
+//	@Success		200	{object}	map[string]interface{}	"Credential request options"
+//	@Failure		500	{object}	map[string]string		"Failed to start login"
+//	@Router			/webauthn/login/begin [post]
+func (h *Handler) BeginWebAuthnLogin(c *gin.Context) {
+	// Listing the credentials of a phone would tell anyone whether it has passkeys,
+	// so the authenticator picks the passkey and reports the user handle instead
+	assertion, session, err := webAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	if err := h.saveWebAuthnCeremony(context.Background(), webAuthnPurposeLogin, primitive.NilObjectID, session); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, assertion)
+}
+
+// FinishWebAuthnLogin godoc
+//
+//	@Summary		Finish passkey login
+//	@Description	Verify the assertion returned by navigator.credentials.get() and return the same response Login returns
+//	@Tags			WebAuthn
+//	@Accept			json
+//	@Produce		json
+//	@Param			credential		body		object					true	"PublicKeyCredential returned by the browser"
+//	@Param			X-Device-Name	header		string					false	"Device name shown in the session list"
+//	@Success		200				{object}	map[string]interface{}	"Access and refresh tokens"
+//	@Failure		400				{object}	map[string]string		"Invalid credential"
+//	@Failure		401				{object}	map[string]string		"Passkey verification failed"
+//	@Failure		403				{object}	map[string]string		"Account is disabled or phone is not verified"
+//	@Failure		500				{object}	map[string]string		"Failed to verify passkey"
+//	@Router			/webauthn/login/finish [post]
+func (h *Handler) FinishWebAuthnLogin(c *gin.Context) {
+	parsed, err := protocol.ParseCredentialRequestResponseBody(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential"})
+		return
+	}
+
+	_, session, err := h.takeWebAuthnCeremony(context.Background(), webAuthnPurposeLogin, parsed.Response.CollectedClientData.Challenge)
+	if errors.Is(err, errWebAuthnCeremonyNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey verification failed"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify passkey"})
+		return
+	}
+
+	// The user handle stored in a discoverable passkey is the hex user ID
+	var user webAuthnUser
+	credential, err := webAuthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		objID, err := primitive.ObjectIDFromHex(string(userHandle))
+		if err != nil {
+			return nil, err
+		}
+		user, err = h.loadWebAuthnUserByID(context.Background(), objID)
+		return user, err
+	}, session, parsed)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey verification failed"})
+		return
+	}
+
+	if err := h.recordWebAuthnUse(context.Background(), credential); err != nil {
+		if errors.Is(err, errWebAuthnCredentialCloned) {
+			log.Printf("Rejected passkey login for %s: signature counter did not increase", user.user.Phone)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Passkey verification failed"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify passkey"})
+		}
+		return
+	}
+
+	if user.user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+	if !user.user.PhoneVerified && unverifiedLoginPolicy == unverifiedLoginDeny {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Phone number is not verified"})
+		return
+	}
+
+	// A passkey verified with a PIN or biometrics already counts as two factors
+	if credential.Flags.UserVerified {
+		h.respondWithTokens(c, user.user)
+		return
+	}
+	h.completeLogin(c, user.user)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// Флаги данных аутентификатора (WebAuthn, 6.1).
+const (
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+)
+
+// softAuthenticator - программный аутентификатор с ключом ES256 и аттестацией none.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+}
+
+func newSoftAuthenticator(t *testing.T, user User) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, credentialID: credentialID, userHandle: []byte(user.ID.Hex())}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// authData собирает данные аутентификатора; для регистрации к ним добавляется открытый ключ.
+func (a *softAuthenticator) authData(t *testing.T, flags byte, signCount uint32) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte(config.WebAuthnRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, signCount)
+	if flags&authDataAttested == 0 {
+		return data
+	}
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, publicKey...)
+}
+
+// clientData возвращает clientDataJSON, который браузер передал бы аутентификатору.
+func clientData(ceremonyType, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge,
+		"origin":    config.WebAuthnRPOrigins[0],
+	})
+	return data
+}
+
+// register возвращает ответ navigator.credentials.create() на challenge.
+func (a *softAuthenticator) register(t *testing.T, challenge string) gin.H {
+	t.Helper()
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(t, authDataUserPresent|authDataUserVerified|authDataAttested, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gin.H{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": gin.H{
+			"clientDataJSON":    b64(clientData("webauthn.create", challenge)),
+			"attestationObject": b64(attestation),
+		},
+	}
+}
+
+// assert возвращает подписанный ответ navigator.credentials.get() на challenge.
+func (a *softAuthenticator) assert(t *testing.T, challenge string, flags byte, signCount uint32) gin.H {
+	t.Helper()
+	authData := a.authData(t, flags, signCount)
+	data := clientData("webauthn.get", challenge)
+	clientDataHash := sha256.Sum256(data)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gin.H{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": gin.H{
+			"clientDataJSON":    b64(data),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	}
+}
+
+// webAuthnOptions - часть параметров церемонии, которую использует тест.
+type webAuthnOptions struct {
+	PublicKey struct {
+		Challenge              string            `json:"challenge"`
+		AllowCredentials       []json.RawMessage `json:"allowCredentials"`
+		AuthenticatorSelection struct {
+			ResidentKey string `json:"residentKey"`
+		} `json:"authenticatorSelection"`
+	} `json:"publicKey"`
+}
+
+// beginWebAuthn начинает церемонию и возвращает ее параметры.
+func beginWebAuthn(t *testing.T, r http.Handler, path, token string, body interface{}) webAuthnOptions {
+	t.Helper()
+	w := doJSON(r, http.MethodPost, path, token, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: status %d, body %s", path, w.Code, w.Body.String())
+	}
+	var options webAuthnOptions
+	decodeBody(t, w, &options)
+	if options.PublicKey.Challenge == "" {
+		t.Fatalf("%s: no challenge in %s", path, w.Body.String())
+	}
+	return options
+}
+
+// registerPasskey регистрирует ключ аутентификатора для пользователя с access-токеном token.
+func registerPasskey(t *testing.T, r http.Handler, token string, authenticator *softAuthenticator) {
+	t.Helper()
+	options := beginWebAuthn(t, r, "/protected/webauthn/register/begin", token, nil)
+	if options.PublicKey.AuthenticatorSelection.ResidentKey != "required" {
+		t.Errorf("residentKey = %q, want required for discoverable login", options.PublicKey.AuthenticatorSelection.ResidentKey)
+	}
+	w := doJSON(r, http.MethodPost, "/protected/webauthn/register/finish?name=Laptop", token,
+		authenticator.register(t, options.PublicKey.Challenge))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: status %d, body %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWebAuthnLogin(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	authenticator := newSoftAuthenticator(t, user)
+	registerPasskey(t, r, loginTestUser(t, r, user.Phone), authenticator)
+
+	credentials, err := h.webAuthnCredentials.ListByUser(context.Background(), user.ID)
+	if err != nil || len(credentials) != 1 || credentials[0].Name != "Laptop" {
+		t.Fatalf("credentials = %+v, %v: want one passkey named Laptop", credentials, err)
+	}
+
+	login := func(t *testing.T, flags byte, signCount uint32) *gin.H {
+		t.Helper()
+		options := beginWebAuthn(t, r, "/webauthn/login/begin", "", nil)
+		w := doJSON(r, http.MethodPost, "/webauthn/login/finish", "", authenticator.assert(t, options.PublicKey.Challenge, flags, signCount))
+		if w.Code != http.StatusOK {
+			t.Logf("status %d, body %s", w.Code, w.Body.String())
+			return nil
+		}
+		var body gin.H
+		decodeBody(t, w, &body)
+		return &body
+	}
+
+	t.Run("discoverable login", func(t *testing.T) {
+		body := login(t, authDataUserPresent|authDataUserVerified, 1)
+		if body == nil || (*body)["token"] == nil {
+			t.Fatalf("response %v, want tokens", body)
+		}
+		if w := doJSON(r, http.MethodGet, "/protected/", (*body)["token"].(string), nil); w.Code != http.StatusOK {
+			t.Errorf("token rejected: status %d", w.Code)
+		}
+	})
+
+	t.Run("clone warning", func(t *testing.T) {
+		// The counter stays at 1, as if a copy of the key signed again
+		if body := login(t, authDataUserPresent|authDataUserVerified, 1); body != nil {
+			t.Errorf("response %v, want the login rejected", *body)
+		}
+		credentials, _ := h.webAuthnCredentials.ListByUser(context.Background(), user.ID)
+		if len(credentials) != 1 || credentials[0].SignCount != 1 {
+			t.Errorf("credentials = %+v, want the counter left at 1", credentials)
+		}
+	})
+
+	t.Run("replayed challenge", func(t *testing.T) {
+		options := beginWebAuthn(t, r, "/webauthn/login/begin", "", nil)
+		assertion := authenticator.assert(t, options.PublicKey.Challenge, authDataUserPresent|authDataUserVerified, 2)
+		if w := doJSON(r, http.MethodPost, "/webauthn/login/finish", "", assertion); w.Code != http.StatusOK {
+			t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+		}
+		if w := doJSON(r, http.MethodPost, "/webauthn/login/finish", "", assertion); w.Code != http.StatusUnauthorized {
+			t.Errorf("replay: status %d, want 401", w.Code)
+		}
+	})
+
+	t.Run("user verification replaces the second factor", func(t *testing.T) {
+		yes, secret := true, "JBSWY3DPEHPK3PXP"
+		if _, err := h.users.Update(context.Background(), user.ID, UserUpdate{TOTPEnabled: &yes, TOTPSecret: &secret}); err != nil {
+			t.Fatal(err)
+		}
+		if body := login(t, authDataUserPresent|authDataUserVerified, 3); body == nil || (*body)["token"] == nil {
+			t.Errorf("verified passkey: response %v, want tokens", body)
+		}
+		if body := login(t, authDataUserPresent, 4); body == nil || (*body)["mfa_required"] != true {
+			t.Errorf("presence only: response %v, want mfa_required", body)
+		}
+	})
+}
+
+func TestBeginWebAuthnLoginDoesNotRevealPasskeys(t *testing.T) {
+	r, h := newTestServer(t)
+	user := createTestUser(t, h, "+77011234567", RoleUser)
+	registerPasskey(t, r, loginTestUser(t, r, user.Phone), newSoftAuthenticator(t, user))
+
+	for name, body := range map[string]interface{}{
+		"phone with a passkey": gin.H{"phone": user.Phone},
+		"unknown phone":        gin.H{"phone": "+77019999999"},
+		"no phone":             nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			options := beginWebAuthn(t, r, "/webauthn/login/begin", "", body)
+			if len(options.PublicKey.AllowCredentials) != 0 {
+				t.Errorf("allowCredentials = %s, want none", options.PublicKey.AllowCredentials)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errWebAuthnCredentialExists   = errors.New("webauthn credential already registered")
+	errWebAuthnCredentialNotFound = errors.New("webauthn credential not found")
+)
+
+// WebAuthnCredentialStore хранит зарегистрированные ключи WebAuthn.
+type WebAuthnCredentialStore interface {
+	// Create сохраняет ключ с заранее заданным идентификатором.
+	// Возвращает errWebAuthnCredentialExists, если ключ с тем же credential ID уже зарегистрирован.
+	Create(ctx context.Context, credential WebAuthnCredential) error
+	// ListByUser возвращает ключи пользователя в порядке регистрации.
+	ListByUser(ctx context.Context, userID primitive.ObjectID) ([]WebAuthnCredential, error)
+	// RecordUse сохраняет счетчик подписей и флаг резервной копии после входа.
+	RecordUse(ctx context.Context, credentialID []byte, signCount uint32, backupState bool, at time.Time) error
+	// Delete удаляет ключ пользователя или возвращает errWebAuthnCredentialNotFound.
+	Delete(ctx context.Context, id, userID primitive.ObjectID) error
+	DeleteByUser(ctx context.Context, userID primitive.ObjectID) error
+}
+
+// WebAuthnCeremonyStore хранит состояние начатых церемоний WebAuthn до их завершения.
+type WebAuthnCeremonyStore interface {
+	Save(ctx context.Context, ceremony WebAuthnCeremony) error
+	// Take находит и удаляет неистекшую на момент now церемонию, поэтому каждый challenge
+	// можно использовать только один раз. Возвращает errWebAuthnCeremonyNotFound, если церемонии нет.
+	Take(ctx context.Context, purpose, challenge string, now time.Time) (WebAuthnCeremony, error)
+}
+
+// mongoWebAuthnCredentialStore хранит ключи в коллекции MongoDB.
+type mongoWebAuthnCredentialStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoWebAuthnCredentialStore) Create(ctx context.Context, credential WebAuthnCredential) error {
+	_, err := s.collection.InsertOne(ctx, credential)
+	if mongo.IsDuplicateKeyError(err) {
+		return errWebAuthnCredentialExists
+	}
+	return err
+}
+
+func (s *mongoWebAuthnCredentialStore) ListByUser(ctx context.Context, userID primitive.ObjectID) ([]WebAuthnCredential, error) {
+	cursor, err := s.collection.Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	credentials := []WebAuthnCredential{}
+	if err := cursor.All(ctx, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (s *mongoWebAuthnCredentialStore) RecordUse(ctx context.Context, credentialID []byte, signCount uint32, backupState bool, at time.Time) error {
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"credential_id": credentialID},
+		bson.M{"$set": bson.M{
+			"sign_count":   signCount,
+			"backup_state": backupState,
+			"last_used_at": at,
+		}},
+	)
+	return err
+}
+
+func (s *mongoWebAuthnCredentialStore) Delete(ctx context.Context, id, userID primitive.ObjectID) error {
+	result, err := s.collection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return errWebAuthnCredentialNotFound
+	}
+	return nil
+}
+
+func (s *mongoWebAuthnCredentialStore) DeleteByUser(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"user_id": userID})
+	return err
+}
+
+// mongoWebAuthnCeremonyStore хранит церемонии в коллекции MongoDB. Истекшие церемонии удаляет TTL-индекс.
+type mongoWebAuthnCeremonyStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoWebAuthnCeremonyStore) Save(ctx context.Context, ceremony WebAuthnCeremony) error {
+	_, err := s.collection.InsertOne(ctx, ceremony)
+	return err
+}
+
+func (s *mongoWebAuthnCeremonyStore) Take(ctx context.Context, purpose, challenge string, now time.Time) (WebAuthnCeremony, error) {
+	var ceremony WebAuthnCeremony
+	err := s.collection.FindOneAndDelete(ctx, bson.M{
+		"challenge":  challenge,
+		"purpose":    purpose,
+		"expires_at": bson.M{"$gt": now},
+	}).Decode(&ceremony)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return WebAuthnCeremony{}, errWebAuthnCeremonyNotFound
+	}
+	return ceremony, err
+}
+
+// memoryWebAuthnCredentialStore хранит ключи в памяти процесса.
+type memoryWebAuthnCredentialStore struct {
+	mu          sync.Mutex
+	credentials map[primitive.ObjectID]WebAuthnCredential
+}
+
+func newMemoryWebAuthnCredentialStore() *memoryWebAuthnCredentialStore {
+	return &memoryWebAuthnCredentialStore{credentials: make(map[primitive.ObjectID]WebAuthnCredential)}
+}
+
+func (s *memoryWebAuthnCredentialStore) Create(_ context.Context, credential WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.credentials {
+		if bytes.Equal(existing.CredentialID, credential.CredentialID) {
+			return errWebAuthnCredentialExists
+		}
+	}
+	s.credentials[credential.ID] = credential
+	return nil
+}
+
+func (s *memoryWebAuthnCredentialStore) ListByUser(_ context.Context, userID primitive.ObjectID) ([]WebAuthnCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credentials := []WebAuthnCredential{}
+	for _, credential := range s.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].ID.Hex() < credentials[j].ID.Hex() })
+	return credentials, nil
+}
+
+func (s *memoryWebAuthnCredentialStore) RecordUse(_ context.Context, credentialID []byte, signCount uint32, backupState bool, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, credential := range s.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			credential.SignCount = signCount
+			credential.BackupState = backupState
+			credential.LastUsedAt = at
+			s.credentials[id] = credential
+		}
+	}
+	return nil
+}
+
+func (s *memoryWebAuthnCredentialStore) Delete(_ context.Context, id, userID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.credentials[id]
+	if !ok || credential.UserID != userID {
+		return errWebAuthnCredentialNotFound
+	}
+	delete(s.credentials, id)
+	return nil
+}
+
+func (s *memoryWebAuthnCredentialStore) DeleteByUser(_ context.Context, userID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, credential := range s.credentials {
+		if credential.UserID == userID {
+			delete(s.credentials, id)
+		}
+	}
+	return nil
+}
+
+// memoryWebAuthnCeremonyStore хранит церемонии в памяти процесса по challenge.
+type memoryWebAuthnCeremonyStore struct {
+	mu         sync.Mutex
+	ceremonies map[string]WebAuthnCeremony
+}
+
+func newMemoryWebAuthnCeremonyStore() *memoryWebAuthnCeremonyStore {
+	return &memoryWebAuthnCeremonyStore{ceremonies: make(map[string]WebAuthnCeremony)}
+}
+
+func (s *memoryWebAuthnCeremonyStore) Save(_ context.Context, ceremony WebAuthnCeremony) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Abandoned ceremonies are dropped here instead of by a TTL index
+	for challenge, existing := range s.ceremonies {
+		if time.Now().After(existing.ExpiresAt) {
+			delete(s.ceremonies, challenge)
+		}
+	}
+	s.ceremonies[ceremony.Challenge] = ceremony
+	return nil
+}
+
+func (s *memoryWebAuthnCeremonyStore) Take(_ context.Context, purpose, challenge string, now time.Time) (WebAuthnCeremony, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ceremony, ok := s.ceremonies[challenge]
+	if !ok || ceremony.Purpose != purpose || !now.Before(ceremony.ExpiresAt) {
+		return WebAuthnCeremony{}, errWebAuthnCeremonyNotFound
+	}
+	delete(s.ceremonies, challenge)
+	return ceremony, nil
+}